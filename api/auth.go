@@ -0,0 +1,290 @@
+package api
+
+import (
+	"crypto/subtle"
+	"detect-server/log"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/spf13/viper"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	// RoleSubmit submit detect requests
+	RoleSubmit = "submit"
+	// RoleRead read tasks, results and jobs
+	RoleRead = "read"
+	// RoleAdmin manage jobs, admin has all roles
+	RoleAdmin = "admin"
+
+	principalKey = "principal"
+)
+
+// ApiKey static key of a client, Name identifies the client in quota and
+// audit log
+type ApiKey struct {
+	Key   string   `mapstructure:"key"`
+	Name  string   `mapstructure:"name"`
+	Roles []string `mapstructure:"roles"`
+}
+
+// AuthOptions requests must be authenticated by one of configured methods,
+// all requests are allowed if no method is configured
+type AuthOptions struct {
+	Keys []ApiKey
+	// JwtPublicKey path of pem public key verifying bearer tokens
+	JwtPublicKey string
+	JwtIssuer    string
+	JwtAudience  string
+	// JwtRolesClaim claim of roles, list or space separated string
+	JwtRolesClaim string
+	// CertRoles roles of client authenticated by verified certificate
+	CertRoles []string
+	// AuditPath file of audit log, denied requests are recorded
+	AuditPath string
+}
+
+func NewAuthOptions() AuthOptions {
+	var options = AuthOptions{
+		JwtPublicKey:  viper.GetString("api.http.auth.jwt.publicKey"),
+		JwtIssuer:     viper.GetString("api.http.auth.jwt.issuer"),
+		JwtAudience:   viper.GetString("api.http.auth.jwt.audience"),
+		JwtRolesClaim: viper.GetString("api.http.auth.jwt.rolesClaim"),
+		CertRoles:     viper.GetStringSlice("api.http.auth.mtls.roles"),
+		AuditPath:     viper.GetString("api.http.auth.audit.path"),
+	}
+	_ = viper.UnmarshalKey("api.http.auth.keys", &options.Keys)
+
+	if options.JwtRolesClaim == "" {
+		options.JwtRolesClaim = "roles"
+	}
+	return options
+}
+
+// Principal authenticated client of request
+type Principal struct {
+	Name   string   `json:"name"`
+	Method string   `json:"method"`
+	Roles  []string `json:"roles"`
+}
+
+// HasRole check role of principal, admin has all roles
+func (principal *Principal) HasRole(role string) bool {
+	for _, r := range principal.Roles {
+		if r == role || r == RoleAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator authenticate request by one kind of credential
+type Authenticator interface {
+	// Authenticate return nil principal without error if request has no
+	// credential of this kind, error if the credential is invalid
+	Authenticate(req *http.Request) (*Principal, error)
+}
+
+// NewAuthenticators create authenticators of configured methods, client
+// certificate is verified by listener, so certificate authenticator is
+// created only if clientCA is configured
+func NewAuthenticators(options AuthOptions, clientCA string) ([]Authenticator, error) {
+	var authenticators []Authenticator
+	if len(options.Keys) > 0 {
+		for _, key := range options.Keys {
+			if key.Key == "" || key.Name == "" {
+				return nil, fmt.Errorf("api key and its name can not be empty")
+			}
+		}
+		authenticators = append(authenticators, &apiKeyAuthenticator{keys: options.Keys})
+	}
+	if options.JwtPublicKey != "" {
+		authenticator, err := newJwtAuthenticator(options)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, authenticator)
+	}
+	if clientCA != "" {
+		authenticators = append(authenticators, &certAuthenticator{roles: options.CertRoles})
+	}
+	return authenticators, nil
+}
+
+type apiKeyAuthenticator struct {
+	keys []ApiKey
+}
+
+func (authenticator *apiKeyAuthenticator) Authenticate(req *http.Request) (*Principal, error) {
+	var key = req.Header.Get(ApiKeyHeader)
+	if key == "" {
+		return nil, nil
+	}
+	for _, k := range authenticator.keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(k.Key)) == 1 {
+			return &Principal{Name: k.Name, Method: "key", Roles: k.Roles}, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid api key")
+}
+
+type jwtAuthenticator struct {
+	parser     *jwt.Parser
+	key        any
+	rolesClaim string
+}
+
+func newJwtAuthenticator(options AuthOptions) (*jwtAuthenticator, error) {
+	data, err := os.ReadFile(options.JwtPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("read jwt public key failed. %s", err)
+	}
+	var authenticator = &jwtAuthenticator{rolesClaim: options.JwtRolesClaim}
+	var methods []string
+	if authenticator.key, err = jwt.ParseRSAPublicKeyFromPEM(data); err == nil {
+		methods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"}
+	} else if authenticator.key, err = jwt.ParseECPublicKeyFromPEM(data); err == nil {
+		methods = []string{"ES256", "ES384", "ES512"}
+	} else if authenticator.key, err = jwt.ParseEdPublicKeyFromPEM(data); err == nil {
+		methods = []string{"EdDSA"}
+	} else {
+		return nil, fmt.Errorf("jwt public key is not rsa, ecdsa or ed25519 key")
+	}
+
+	var parserOptions = []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired()}
+	if options.JwtIssuer != "" {
+		parserOptions = append(parserOptions, jwt.WithIssuer(options.JwtIssuer))
+	}
+	if options.JwtAudience != "" {
+		parserOptions = append(parserOptions, jwt.WithAudience(options.JwtAudience))
+	}
+	authenticator.parser = jwt.NewParser(parserOptions...)
+	return authenticator, nil
+}
+
+func (authenticator *jwtAuthenticator) Authenticate(req *http.Request) (*Principal, error) {
+	var token, ok = strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, nil
+	}
+	var claims = jwt.MapClaims{}
+	if _, err := authenticator.parser.ParseWithClaims(token, claims, func(*jwt.Token) (any, error) {
+		return authenticator.key, nil
+	}); err != nil {
+		return nil, fmt.Errorf("invalid bearer token. %s", err)
+	}
+	subject, _ := claims.GetSubject()
+	if subject == "" {
+		return nil, fmt.Errorf("invalid bearer token. subject is empty")
+	}
+
+	var principal = &Principal{Name: subject, Method: "jwt"}
+	switch roles := claims[authenticator.rolesClaim].(type) {
+	case string:
+		principal.Roles = strings.Fields(roles)
+	case []any:
+		for _, role := range roles {
+			if r, ok := role.(string); ok {
+				principal.Roles = append(principal.Roles, r)
+			}
+		}
+	}
+	return principal, nil
+}
+
+// certAuthenticator client certificate has been verified by listener
+type certAuthenticator struct {
+	roles []string
+}
+
+func (authenticator *certAuthenticator) Authenticate(req *http.Request) (*Principal, error) {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return nil, nil
+	}
+	var cert = req.TLS.VerifiedChains[0][0]
+	return &Principal{Name: cert.Subject.CommonName, Method: "mtls", Roles: authenticator.roles}, nil
+}
+
+var errCredentialRequired = errors.New("credential is required")
+
+// authenticate find principal of request by authenticators in order, all
+// requests are allowed if no authenticator is added
+func (api *HttpApi) authenticate(ctx *gin.Context) {
+	principal, err := api.authenticateRequest(ctx.Request)
+	if err != nil {
+		api.deny(ctx, http.StatusUnauthorized, nil, err)
+		return
+	}
+	if principal != nil {
+		ctx.Set(principalKey, principal)
+	}
+	ctx.Next()
+}
+
+// authenticateRequest return nil principal without error if no
+// authenticator is added, it is shared by http and grpc api
+func (api *HttpApi) authenticateRequest(req *http.Request) (*Principal, error) {
+	if len(api.authenticators) == 0 {
+		return nil, nil
+	}
+	for _, authenticator := range api.authenticators {
+		principal, err := authenticator.Authenticate(req)
+		if err != nil {
+			return nil, err
+		}
+		if principal != nil {
+			return principal, nil
+		}
+	}
+	return nil, errCredentialRequired
+}
+
+// authorize require principal of request having role
+func (api *HttpApi) authorize(role string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var principal = principalOf(ctx)
+		if principal == nil || principal.HasRole(role) {
+			ctx.Next()
+			return
+		}
+		api.deny(ctx, http.StatusForbidden, principal, fmt.Errorf("role %s is required", role))
+	}
+}
+
+// deny abort request and record it in audit log
+func (api *HttpApi) deny(ctx *gin.Context, status int, principal *Principal, err error) {
+	api.auditDenied(principal, err, "status", status, "method", ctx.Request.Method,
+		"path", ctx.Request.URL.Path, "remote", ctx.ClientIP())
+	ctx.AbortWithStatusJSON(status, NewCommonResponse(1, err.Error(), nil))
+}
+
+// auditDenied record denied request with fields describing it
+func (api *HttpApi) auditDenied(principal *Principal, err error, fields ...any) {
+	fields = append(fields, "reason", err.Error())
+	if principal != nil {
+		fields = append(fields, "principal", principal.Name, "auth", principal.Method)
+	}
+	api.audit.Infow("request denied", fields...)
+}
+
+// principalOf return principal of request, nil if authentication is
+// disabled
+func principalOf(ctx *gin.Context) *Principal {
+	if value, ok := ctx.Get(principalKey); ok {
+		return value.(*Principal)
+	}
+	return nil
+}
+
+// AddAuthenticator authenticate requests by authenticator, audit log is
+// created when the first authenticator is added
+func (api *HttpApi) AddAuthenticator(authenticator Authenticator) {
+	if api.audit == nil {
+		api.audit = log.NewAuditLogger(api.options.Auth.AuditPath)
+	}
+	api.authenticators = append(api.authenticators, authenticator)
+}
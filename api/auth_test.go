@@ -0,0 +1,90 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"detect-server/log"
+	"encoding/pem"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func init() {
+	log.Logger = zap.NewNop().Sugar()
+	gin.SetMode(gin.TestMode)
+}
+
+func TestHttpApi_Authenticate(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(publicKey)
+	var keyPath = filepath.Join(t.TempDir(), "jwt.pem")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600); err != nil {
+		t.Fatalf("write public key failed. %s", err)
+	}
+	var sign = func(claims jwt.MapClaims) string {
+		token, _ := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims).SignedString(privateKey)
+		return token
+	}
+	var expiresAt = time.Now().Add(time.Hour).Unix()
+
+	authenticators, err := NewAuthenticators(AuthOptions{
+		Keys: []ApiKey{
+			{Key: "reader-key", Name: "reader", Roles: []string{RoleRead}},
+			{Key: "admin-key", Name: "admin", Roles: []string{RoleAdmin}},
+		},
+		JwtPublicKey:  keyPath,
+		JwtIssuer:     "detect",
+		JwtRolesClaim: "roles",
+	}, "")
+	if err != nil {
+		t.Fatalf("NewAuthenticators() error = %v", err)
+	}
+	var api = &HttpApi{srv: gin.New()}
+	for _, authenticator := range authenticators {
+		api.AddAuthenticator(authenticator)
+	}
+	api.srv.Use(api.authenticate)
+	api.srv.POST("/detects", api.authorize(RoleSubmit), func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, principalOf(ctx).Name)
+	})
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+	}{
+		{name: "no credential", status: http.StatusUnauthorized},
+		{name: "invalid key", headers: map[string]string{ApiKeyHeader: "unknown"}, status: http.StatusUnauthorized},
+		{name: "missing role", headers: map[string]string{ApiKeyHeader: "reader-key"}, status: http.StatusForbidden},
+		{name: "admin has all roles", headers: map[string]string{ApiKeyHeader: "admin-key"}, status: http.StatusOK},
+		{name: "jwt", headers: map[string]string{"Authorization": "Bearer " + sign(jwt.MapClaims{
+			"sub": "ci", "iss": "detect", "exp": expiresAt, "roles": []string{RoleSubmit}})}, status: http.StatusOK},
+		{name: "jwt space separated roles", headers: map[string]string{"Authorization": "Bearer " + sign(jwt.MapClaims{
+			"sub": "ci", "iss": "detect", "exp": expiresAt, "roles": "read submit"})}, status: http.StatusOK},
+		{name: "jwt wrong issuer", headers: map[string]string{"Authorization": "Bearer " + sign(jwt.MapClaims{
+			"sub": "ci", "iss": "other", "exp": expiresAt, "roles": []string{RoleSubmit}})}, status: http.StatusUnauthorized},
+		{name: "jwt without exp", headers: map[string]string{"Authorization": "Bearer " + sign(jwt.MapClaims{
+			"sub": "ci", "iss": "detect", "roles": []string{RoleSubmit}})}, status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req = httptest.NewRequest(http.MethodPost, "/detects", nil)
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			var recorder = httptest.NewRecorder()
+			api.srv.ServeHTTP(recorder, req)
+			if recorder.Code != tt.status {
+				t.Errorf("status = %d, want %d, body %s", recorder.Code, tt.status, recorder.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,456 @@
+package api
+
+import (
+	"context"
+	"detect-server/api/pb"
+	"detect-server/detector"
+	"detect-server/dispatcher"
+	"detect-server/log"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// GrpcApiOptions grpc api is disabled if Listen is empty
+type GrpcApiOptions struct {
+	Listen string
+}
+
+func NewGrpcApiOptions() GrpcApiOptions {
+	return GrpcApiOptions{
+		Listen: viper.GetString("api.grpc.listen"),
+	}
+}
+
+// grpcRoles role required by every method
+var grpcRoles = map[string]string{
+	pb.DetectService_SubmitTask_FullMethodName:    RoleSubmit,
+	pb.DetectService_Detect_FullMethodName:        RoleSubmit,
+	pb.DetectService_StreamResults_FullMethodName: RoleRead,
+	pb.DetectService_GetTask_FullMethodName:       RoleRead,
+	pb.DetectService_GetTaskResult_FullMethodName: RoleRead,
+	pb.DetectService_CancelTask_FullMethodName:    RoleSubmit,
+}
+
+type principalContextKey struct{}
+
+// GrpcApi serve DetectService, components, validation, quota, tls and
+// authentication are shared with http api
+type GrpcApi struct {
+	pb.UnimplementedDetectServiceServer
+	options GrpcApiOptions
+	http    *HttpApi
+	// lock guards srv and stopped, api stopped before serving never serves
+	lock    sync.Mutex
+	srv     *grpc.Server
+	stopped bool
+}
+
+func NewGrpcApi(options GrpcApiOptions, httpApi *HttpApi) *GrpcApi {
+	return &GrpcApi{
+		options: options,
+		http:    httpApi,
+	}
+}
+
+func (api *GrpcApi) Start() error {
+	var serverOptions = []grpc.ServerOption{
+		grpc.UnaryInterceptor(api.authenticateUnary),
+		grpc.StreamInterceptor(api.authenticateStream),
+	}
+	if tlsOptions := api.http.options.Tls; tlsOptions.Cert != "" {
+		reloader, err := newCertReloader(tlsOptions.Cert, tlsOptions.Key, tlsOptions.ReloadInterval)
+		if err != nil {
+			return err
+		}
+		defer reloader.close()
+		tlsConfig, err := newTlsConfig(tlsOptions, reloader)
+		if err != nil {
+			return err
+		}
+		go reloader.watch()
+		serverOptions = append(serverOptions, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	listener, err := net.Listen("tcp", api.options.Listen)
+	if err != nil {
+		return err
+	}
+	var srv = grpc.NewServer(serverOptions...)
+	pb.RegisterDetectServiceServer(srv, api)
+	api.lock.Lock()
+	if api.stopped {
+		api.lock.Unlock()
+		return listener.Close()
+	}
+	api.srv = srv
+	api.lock.Unlock()
+	log.Logger.Infof("grpc api serves on %s", api.options.Listen)
+	if err = srv.Serve(listener); !errors.Is(err, grpc.ErrServerStopped) {
+		return err
+	}
+	return nil
+}
+
+// Stop stop accepting calls and wait active calls until ctx is done, calls
+// left are closed then
+func (api *GrpcApi) Stop(ctx context.Context) error {
+	api.lock.Lock()
+	api.stopped = true
+	var srv = api.srv
+	api.lock.Unlock()
+	if srv == nil {
+		return nil
+	}
+	var stopped = make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		srv.Stop()
+		return ctx.Err()
+	}
+}
+
+// authenticate authenticate metadata and client certificate of call by
+// authenticators of http api, and check role of method
+func (api *GrpcApi) authenticate(ctx context.Context, method string) (context.Context, error) {
+	var req = &http.Request{Method: http.MethodPost, Header: http.Header{}}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for key, values := range md {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	var remote string
+	if p, ok := peer.FromContext(ctx); ok {
+		remote = p.Addr.String()
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			req.TLS = &tlsInfo.State
+		}
+	}
+
+	principal, err := api.http.authenticateRequest(req)
+	if err != nil {
+		api.http.auditDenied(nil, err, "code", codes.Unauthenticated.String(), "method", method, "remote", remote)
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if principal == nil {
+		return ctx, nil
+	}
+	if role := grpcRoles[method]; !principal.HasRole(role) {
+		err = fmt.Errorf("role %s is required", role)
+		api.http.auditDenied(principal, err, "code", codes.PermissionDenied.String(), "method", method, "remote", remote)
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	return context.WithValue(ctx, principalContextKey{}, principal), nil
+}
+
+func (api *GrpcApi) authenticateUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (any, error) {
+	ctx, err := api.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (api *GrpcApi) authenticateStream(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+	ctx, err := api.authenticate(stream.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: stream, ctx: ctx})
+}
+
+// authenticatedStream carry principal in context of stream
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream *authenticatedStream) Context() context.Context {
+	return stream.ctx
+}
+
+// grpcClientKey identify client of call like clientKey of http api
+func grpcClientKey(ctx context.Context) string {
+	if principal, ok := ctx.Value(principalContextKey{}).(*Principal); ok {
+		return principal.Method + ":" + principal.Name
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return "ip:" + host
+		}
+	}
+	return "ip:"
+}
+
+// grpcError convert error of submitting targets to status
+func grpcError(err error) error {
+	var quotaErr *QuotaError
+	switch {
+	case errors.As(err, &quotaErr):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, dispatcher.ErrTaskNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, dispatcher.ErrTaskFinished):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return status.Error(codes.InvalidArgument, err.Error())
+}
+
+// convertRequest validate and convert request to targets like payload of
+// http api
+func (api *GrpcApi) convertRequest(req *pb.DetectRequest) (detector.TargetIterator, error) {
+	var payload = DetectPayload{}
+	if icmp := req.GetIcmp(); icmp != nil {
+		payload.Icmp = &IcmpDetectPayload{
+			Timeout: int(icmp.Timeout),
+			Count:   int(icmp.Count),
+			Targets: icmp.Targets,
+		}
+	}
+	if tcp := req.GetTcp(); tcp != nil {
+		payload.Tcp = &TcpDetectPayload{
+			Timeout: int(tcp.Timeout),
+			Count:   int(tcp.Count),
+			Ports:   toInts(tcp.Ports),
+			Targets: tcp.Targets,
+		}
+	}
+	if udp := req.GetUdp(); udp != nil {
+		payload.Udp = &UdpDetectPayload{
+			Timeout:  int(udp.Timeout),
+			Count:    int(udp.Count),
+			Ports:    toInts(udp.Ports),
+			Payload:  udp.Payload,
+			Template: udp.Template,
+			Targets:  udp.Targets,
+		}
+	}
+	if h := req.GetHttp(); h != nil {
+		payload.Http = &HttpDetectPayload{
+			Timeout:         int(h.Timeout),
+			Count:           int(h.Count),
+			Method:          h.Method,
+			Headers:         h.Headers,
+			Body:            h.Body,
+			ExpectedStatus:  toInts(h.ExpectedStatus),
+			BodyRegex:       h.BodyRegex,
+			BodyContains:    h.BodyContains,
+			FollowRedirects: h.FollowRedirects,
+			MaxRedirects:    int(h.MaxRedirects),
+			Insecure:        h.Insecure,
+			Targets:         h.Targets,
+		}
+	}
+	if err := validatePayload(&payload); err != nil {
+		return nil, err
+	}
+	return api.http.converter.convertPayloadToTargets(payload)
+}
+
+func (api *GrpcApi) SubmitTask(ctx context.Context, req *pb.DetectRequest) (*pb.TaskStatus, error) {
+	priority, err := parsePriority(req.GetPriority())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	targets, err := api.convertRequest(req)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	var name = req.GetName()
+	if name == "" {
+		name = "detect"
+	}
+	taskStatus, err := api.http.submitTask(grpcClientKey(ctx), name, priority, targets)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toPbTaskStatus(taskStatus), nil
+}
+
+func (api *GrpcApi) Detect(ctx context.Context, req *pb.DetectRequest) (*pb.DetectResponse, error) {
+	targets, err := api.convertRequest(req)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	messages, err := api.http.detectTargets(ctx, grpcClientKey(ctx), targets)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	var resp = &pb.DetectResponse{Results: make([]*pb.DetectResult, 0, len(messages))}
+	for _, message := range messages {
+		resp.Results = append(resp.Results, toPbDetectResult(message))
+	}
+	return resp, nil
+}
+
+func (api *GrpcApi) StreamResults(req *pb.TaskRequest, stream pb.DetectService_StreamResultsServer) error {
+	events, cancel, ok := api.http.subscribeTask(req.GetId())
+	if !ok {
+		return status.Error(codes.NotFound, "task not found")
+	}
+	defer cancel()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			var taskEvent = &pb.TaskEvent{}
+			switch data := event.Data.(type) {
+			case dispatcher.DefaultMessage:
+				taskEvent.Event = &pb.TaskEvent_Result{Result: toPbDetectResult(data)}
+			case dispatcher.TaskResult:
+				taskEvent.Event = &pb.TaskEvent_Completed{Completed: toPbTaskResult(data)}
+			default:
+				continue
+			}
+			if err := stream.Send(taskEvent); err != nil {
+				return err
+			}
+			if event.Event == dispatcher.CompletedEvent {
+				return nil
+			}
+		}
+	}
+}
+
+func (api *GrpcApi) GetTask(_ context.Context, req *pb.TaskRequest) (*pb.TaskStatus, error) {
+	taskStatus, ok := api.http.tracker.Get(req.GetId())
+	if !ok {
+		return nil, status.Error(codes.NotFound, "task not found")
+	}
+	return toPbTaskStatus(taskStatus), nil
+}
+
+func (api *GrpcApi) GetTaskResult(_ context.Context, req *pb.TaskResultRequest) (*pb.TaskResult, error) {
+	result, ok := api.http.tracker.Result(req.GetId(), req.GetTargets())
+	if !ok {
+		return nil, status.Error(codes.NotFound, "task not found")
+	}
+	return toPbTaskResult(result), nil
+}
+
+func (api *GrpcApi) CancelTask(_ context.Context, req *pb.TaskRequest) (*pb.TaskStatus, error) {
+	taskStatus, err := api.http.dispatch.Cancel(req.GetId())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toPbTaskStatus(taskStatus), nil
+}
+
+func toInts(values []int32) []int {
+	var ints = make([]int, 0, len(values))
+	for _, value := range values {
+		ints = append(ints, int(value))
+	}
+	return ints
+}
+
+// toPbTime zero time is converted to nil
+func toPbTime(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func toPbTaskStatus(taskStatus dispatcher.TaskStatus) *pb.TaskStatus {
+	return &pb.TaskStatus{
+		Id:         taskStatus.Id,
+		Name:       taskStatus.Name,
+		Priority:   taskStatus.Priority,
+		State:      taskStatus.State,
+		Total:      int64(taskStatus.Total),
+		Dispatched: int64(taskStatus.Dispatched),
+		Completed:  int64(taskStatus.Completed),
+		Failed:     int64(taskStatus.Failed),
+		Filtered:   int64(taskStatus.Filtered),
+		CreatedAt:  toPbTime(taskStatus.CreatedAt),
+		StartedAt:  toPbTime(taskStatus.StartedAt),
+		FinishedAt: toPbTime(taskStatus.FinishedAt),
+	}
+}
+
+func toPbDetectResult(message dispatcher.DefaultMessage) *pb.DetectResult {
+	var summary = message.Summary
+	var result = &pb.DetectResult{
+		TaskId: message.TaskId,
+		Type:   message.Type,
+		Target: message.Target,
+		Count:  int32(message.Count),
+		Summary: &pb.Summary{
+			Success:    summary.Success,
+			Sent:       int32(summary.Sent),
+			Received:   int32(summary.Received),
+			Loss:       summary.Loss,
+			MinLatency: durationpb.New(summary.MinLatency),
+			AvgLatency: durationpb.New(summary.AvgLatency),
+			MaxLatency: durationpb.New(summary.MaxLatency),
+			ErrorClass: summary.ErrorClass,
+		},
+	}
+	for _, latency := range summary.Latencies {
+		result.Summary.Latencies = append(result.Summary.Latencies, durationpb.New(latency))
+	}
+	if message.Detail != nil {
+		result.Detail, _ = json.Marshal(message.Detail)
+	}
+	if message.Error != nil {
+		result.Error = message.Error.Error()
+	}
+	return result
+}
+
+func toPbTaskResult(taskResult dispatcher.TaskResult) *pb.TaskResult {
+	var result = &pb.TaskResult{
+		TaskId:     taskResult.TaskId,
+		Name:       taskResult.Name,
+		State:      taskResult.State,
+		Total:      int64(taskResult.Total),
+		Alive:      int64(taskResult.Alive),
+		Dead:       int64(taskResult.Dead),
+		Errors:     int64(taskResult.Errors),
+		Filtered:   int64(taskResult.Filtered),
+		MinLatency: durationpb.New(taskResult.MinLatency),
+		AvgLatency: durationpb.New(taskResult.AvgLatency),
+		MaxLatency: durationpb.New(taskResult.MaxLatency),
+	}
+	for _, target := range taskResult.Targets {
+		result.Targets = append(result.Targets, &pb.TargetResult{
+			Type:       target.Type,
+			Target:     target.Target,
+			Success:    target.Success,
+			Loss:       target.Loss,
+			MinLatency: durationpb.New(target.MinLatency),
+			AvgLatency: durationpb.New(target.AvgLatency),
+			MaxLatency: durationpb.New(target.MaxLatency),
+			ErrorClass: target.ErrorClass,
+			Error:      target.Error,
+		})
+	}
+	return result
+}
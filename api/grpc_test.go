@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"detect-server/api/pb"
+	"detect-server/connector"
+	"detect-server/dispatcher"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGrpcApi_SubmitTask(t *testing.T) {
+	var options = HttpApiOptions{MaxDetectTargets: 100, MaxHostBits: 16, MaxSyncTargets: 16,
+		Quota: QuotaOptions{TargetsPerMinute: 10}}
+	var httpApi = NewHttpApi(options)
+	var tracker = dispatcher.NewTracker(dispatcher.TrackerOptions{Retention: time.Minute})
+	var publisher = connector.NewChanConnector[dispatcher.Task](connector.Options{MaxBufferSize: 10})
+	httpApi.AddTracker(tracker)
+	httpApi.AddTaskPublisher(publisher)
+	authenticators, _ := NewAuthenticators(AuthOptions{Keys: []ApiKey{
+		{Key: "submit-key", Name: "submitter", Roles: []string{RoleSubmit}},
+	}}, "")
+	httpApi.AddAuthenticator(authenticators[0])
+
+	var api = NewGrpcApi(GrpcApiOptions{}, httpApi)
+	var listener = bufconn.Listen(1 << 20)
+	var srv = grpc.NewServer(grpc.UnaryInterceptor(api.authenticateUnary), grpc.StreamInterceptor(api.authenticateStream))
+	pb.RegisterDetectServiceServer(srv, api)
+	go func() { _ = srv.Serve(listener) }()
+	defer srv.Stop()
+
+	conn, err := grpc.Dial("bufnet", grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }))
+	if err != nil {
+		t.Fatalf("dial failed. %s", err)
+	}
+	defer conn.Close()
+	var client = pb.NewDetectServiceClient(conn)
+	var ctx = metadata.AppendToOutgoingContext(context.Background(), ApiKeyHeader, "submit-key")
+
+	taskStatus, err := client.SubmitTask(ctx, &pb.DetectRequest{
+		Icmp: &pb.IcmpPayload{Targets: []string{"10.0.0.0/29", "!10.0.0.0"}},
+	})
+	if err != nil {
+		t.Fatalf("SubmitTask() error = %v", err)
+	}
+	if taskStatus.Total != 7 || taskStatus.Name != "detect" || taskStatus.Priority != dispatcher.PriorityNormal {
+		t.Errorf("SubmitTask() status = %v, want 7 targets of normal priority", taskStatus)
+	}
+	select {
+	case task := <-publisher.Receive():
+		if task.Id() != taskStatus.Id {
+			t.Errorf("published task = %s, want %s", task.Id(), taskStatus.Id)
+		}
+	default:
+		t.Errorf("task is not published")
+	}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		req  *pb.DetectRequest
+		code codes.Code
+	}{
+		{name: "invalid target", ctx: ctx,
+			req: &pb.DetectRequest{Icmp: &pb.IcmpPayload{Targets: []string{"10.0.0.1-x"}}}, code: codes.InvalidArgument},
+		{name: "udp without payload", ctx: ctx,
+			req: &pb.DetectRequest{Udp: &pb.UdpPayload{Ports: []int32{53}, Targets: []string{"10.0.0.1"}}}, code: codes.InvalidArgument},
+		{name: "invalid priority", ctx: ctx,
+			req: &pb.DetectRequest{Priority: "urgent", Icmp: &pb.IcmpPayload{Targets: []string{"10.0.0.1"}}}, code: codes.InvalidArgument},
+		{name: "quota exceeded", ctx: ctx,
+			req: &pb.DetectRequest{Icmp: &pb.IcmpPayload{Targets: []string{"10.0.0.0/29"}}}, code: codes.ResourceExhausted},
+		{name: "unauthenticated", ctx: context.Background(),
+			req: &pb.DetectRequest{Icmp: &pb.IcmpPayload{Targets: []string{"10.0.0.1"}}}, code: codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.SubmitTask(tt.ctx, tt.req)
+			if status.Code(err) != tt.code {
+				t.Errorf("SubmitTask() error = %v, want %s", err, tt.code)
+			}
+		})
+	}
+
+	if _, err = client.GetTask(ctx, &pb.TaskRequest{Id: taskStatus.Id}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("GetTask() without read role error = %v, want %s", err, codes.PermissionDenied)
+	}
+}
@@ -1,22 +1,26 @@
 package api
 
 import (
+	"context"
 	"detect-server/connector"
 	"detect-server/detector"
 	"detect-server/dispatcher"
+	"detect-server/log"
+	"detect-server/scheduler"
 	"detect-server/tools"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
-type IcmpDetectPayload struct {
-	Timeout int      `json:"timeout"`
-	Count   int      `json:"count"`
-	Type    string   `json:"type"`
-	Targets []string `json:"targets"`
-}
-
 type CommonResponse struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
@@ -31,101 +35,392 @@ func NewCommonResponse(code int, msg string, data any) CommonResponse {
 	}
 }
 
+// AcceptedTask response of submitted task, progress of task such as
+// filtered targets is counted while it is dispatched, see GET /tasks/{id}
+type AcceptedTask struct {
+	Id        string               `json:"id"`
+	Name      string               `json:"name"`
+	Priority  dispatcher.Priority  `json:"priority"`
+	State     dispatcher.TaskState `json:"state"`
+	Total     int                  `json:"total"`
+	CreatedAt time.Time            `json:"createdAt"`
+}
+
+func NewAcceptedTask(status dispatcher.TaskStatus) AcceptedTask {
+	return AcceptedTask{
+		Id:        status.Id,
+		Name:      status.Name,
+		Priority:  status.Priority,
+		State:     status.State,
+		Total:     status.Total,
+		CreatedAt: status.CreatedAt,
+	}
+}
+
+// HttpApiOptions MaxDetectTargets limit expanded targets of every request,
+// MaxSyncTargets and SyncTimeout limit detect request waiting for results,
+// MaxHostBits limit size of every subnet in request
 type HttpApiOptions struct {
 	Listen           string
 	MaxDetectTargets int
+	MaxSyncTargets   int
+	SyncTimeout      time.Duration
+	MaxHostBits      int
+	// TrustedProxies networks of proxies whose X-Forwarded-For is taken as
+	// client ip, none is trusted if it is empty
+	TrustedProxies []string
+	// AllowedOrigins origins of browser pages allowed to open websocket
+	// besides the origin of api itself, e.g. https://console.example.com
+	AllowedOrigins []string
+	Quota          QuotaOptions
+	Auth           AuthOptions
+	Tls            TlsOptions
 }
 
 func NewHttpApiOptions() HttpApiOptions {
 	var options = HttpApiOptions{
-		Listen: viper.GetString("api.http.listen"),
+		Listen:           viper.GetString("api.http.listen"),
+		MaxDetectTargets: viper.GetInt("api.http.maxTargets"),
+		MaxSyncTargets:   viper.GetInt("api.http.sync.maxTargets"),
+		SyncTimeout:      time.Duration(viper.GetInt("api.http.sync.timeout")) * time.Millisecond,
+		MaxHostBits:      viper.GetInt("api.http.subnet.maxHostBits"),
+		TrustedProxies:   viper.GetStringSlice("api.http.trustedProxies"),
+		AllowedOrigins:   viper.GetStringSlice("api.http.allowedOrigins"),
+		Quota:            NewQuotaOptions(),
+		Auth:             NewAuthOptions(),
+		Tls:              NewTlsOptions(),
 	}
 
 	if options.Listen == "" {
 		options.Listen = "0.0.0.0:8080"
 	}
+	if options.MaxDetectTargets <= 0 {
+		options.MaxDetectTargets = 65536
+	}
+	if options.MaxSyncTargets <= 0 {
+		options.MaxSyncTargets = 16
+	}
+	if options.SyncTimeout <= 0 {
+		options.SyncTimeout = 10 * time.Second
+	}
+	if options.MaxHostBits <= 0 {
+		options.MaxHostBits = 16
+	}
 	return options
 }
 
 type HttpApi struct {
-	srv           *gin.Engine
-	options       HttpApiOptions
-	icmpPublisher connector.Publisher[dispatcher.Task[detector.IcmpOptions]]
+	srv            *gin.Engine
+	server         *http.Server
+	options        HttpApiOptions
+	converter      payloadConverter
+	quota          *quotaManager
+	authenticators []Authenticator
+	audit          *zap.SugaredLogger
+	taskPublisher  connector.Publisher[dispatcher.Task]
+	tracker        dispatcher.Tracker
+	dispatch       dispatcher.Dispatcher
+	broker         dispatcher.Broker
+	scheduler      scheduler.Scheduler
+	upgrader       websocket.Upgrader
 }
 
-func (api *HttpApi) AddIcmpPublisher(publisher connector.Publisher[dispatcher.Task[detector.IcmpOptions]]) {
-	api.icmpPublisher = publisher
+func (api *HttpApi) AddTaskPublisher(publisher connector.Publisher[dispatcher.Task]) {
+	api.taskPublisher = publisher
 }
 
-func convertPayloadToIcmpTask(payload IcmpDetectPayload) ([]dispatcher.Task[detector.IcmpOptions], error) {
-	var tasks = make([]dispatcher.Task[detector.IcmpOptions], 0)
-	var err error
-	if payload.Type == "subnet" {
-		for _, subnet := range payload.Targets {
-			var ips []string
-			ips, err = tools.ListIpsInNetwork(subnet)
-			if err != nil {
-				return nil, err
-			}
-			var options = detector.DetectOptions[detector.IcmpOptions]{
-				Count:   payload.Count,
-				Timeout: payload.Timeout,
-			}
-			var detects = make([]detector.DetectTarget[detector.IcmpOptions], 0)
-			for _, target := range ips {
-				detects = append(detects, detector.NewDetectTarget(detector.ICMPDetect, target, options))
-			}
-			tasks = append(tasks, dispatcher.NewTask[detector.IcmpOptions](subnet, detects))
-		}
-	} else {
-		for _, target := range payload.Targets {
-			var options = detector.DetectOptions[detector.IcmpOptions]{
-				Count:   payload.Count,
-				Timeout: payload.Timeout,
-			}
-			var detect = detector.NewDetectTarget(detector.ICMPDetect, target, options)
-			tasks = append(tasks, dispatcher.NewTask[detector.IcmpOptions]("task", []detector.DetectTarget[detector.IcmpOptions]{detect}))
+func (api *HttpApi) AddTracker(tracker dispatcher.Tracker) {
+	api.tracker = tracker
+	api.quota.tracker = tracker
+}
+
+func (api *HttpApi) AddDispatcher(dispatch dispatcher.Dispatcher) {
+	api.dispatch = dispatch
+}
+
+func (api *HttpApi) AddBroker(broker dispatcher.Broker) {
+	api.broker = broker
+}
+
+// handleDetect decode and validate payload, convert it to targets and
+// publish all targets as one task, status of the task is responded with
+// 202. if query wait is true, targets are detected sync and results are
+// responded. request exceeds quota of client is responded with 429
+func handleDetect[P any](api *HttpApi, ctx *gin.Context, name string, convert func(P) (detector.TargetIterator, error)) {
+	priority, err := parsePriority(ctx.Query("priority"))
+	if err != nil {
+		respondError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	var payload P
+	if err := decodePayload(ctx.Request.Body, &payload); err != nil {
+		respondError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	targets, err := convert(payload)
+	if err != nil {
+		respondError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	var client = clientKey(ctx)
+	if ctx.Query("wait") == "true" {
+		messages, err := api.detectTargets(ctx.Request.Context(), client, targets)
+		if err != nil {
+			respondError(ctx, http.StatusBadRequest, err)
+			return
 		}
+		ctx.JSON(http.StatusOK, NewCommonResponse(0, "ok", messages))
+		return
 	}
 
-	return tasks, err
+	status, err := api.submitTask(client, name, priority, targets)
+	if err != nil {
+		respondError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	ctx.JSON(http.StatusAccepted, NewCommonResponse(0, "ok", NewAcceptedTask(status)))
+}
+
+// submitTask check targets and quota of client, then publish all targets
+// as one task of priority
+func (api *HttpApi) submitTask(client string, name string, priority dispatcher.Priority,
+	targets detector.TargetIterator) (dispatcher.TaskStatus, error) {
+	if err := api.checkTargets(targets); err != nil {
+		return dispatcher.TaskStatus{}, err
+	}
+	var task = dispatcher.NewTask(name, priority, targets)
+	if err := api.quota.acquire(client, targets.Count(), task.Id()); err != nil {
+		return dispatcher.TaskStatus{}, err
+	}
+	// task is held until tracker knows it, so concurrent submits count it
+	defer api.quota.release(client, task.Id())
+	var status = api.tracker.Track(task)
+	api.taskPublisher.Publish() <- task
+	return status, nil
+}
+
+// detectTargets check targets and quota of client, then detect targets
+// and wait results, targets not finished before SyncTimeout are returned
+// with timeout error
+func (api *HttpApi) detectTargets(ctx context.Context, client string, targets detector.TargetIterator) ([]dispatcher.DefaultMessage, error) {
+	if err := api.checkTargets(targets); err != nil {
+		return nil, err
+	}
+	if targets.Count() > api.options.MaxSyncTargets {
+		return nil, fmt.Errorf("too many targets to wait, max %d", api.options.MaxSyncTargets)
+	}
+	// request waiting results counts as running task of client
+	var syncId = "sync:" + uuid.NewString()
+	if err := api.quota.acquire(client, targets.Count(), syncId); err != nil {
+		return nil, err
+	}
+	defer api.quota.release(client, syncId)
+	var timeoutCtx, cancel = context.WithTimeout(ctx, api.options.SyncTimeout)
+	defer cancel()
+	return api.dispatch.Detect(timeoutCtx, detector.CollectTargets(targets)), nil
+}
+
+func (api *HttpApi) HandleDetect(ctx *gin.Context) {
+	handleDetect(api, ctx, "detect", api.converter.convertPayloadToTargets)
 }
 
 func (api *HttpApi) HandleIcmpDetect(ctx *gin.Context) {
-	var payload = IcmpDetectPayload{}
-	var err = ctx.BindJSON(&payload)
+	handleDetect(api, ctx, detector.ICMPDetect, api.converter.convertPayloadToIcmpTargets)
+}
+
+func (api *HttpApi) HandleTcpDetect(ctx *gin.Context) {
+	handleDetect(api, ctx, detector.TCPDetect, api.converter.convertPayloadToTcpTargets)
+}
+
+func (api *HttpApi) HandleUdpDetect(ctx *gin.Context) {
+	handleDetect(api, ctx, detector.UDPDetect, api.converter.convertPayloadToUdpTargets)
+}
+
+func (api *HttpApi) HandleHttpDetect(ctx *gin.Context) {
+	handleDetect(api, ctx, detector.HTTPDetect, api.converter.convertPayloadToHttpTargets)
+}
+
+// ConvertPayload convert json payload of detect api to targets, empty
+// detect type means mixed DetectPayload
+func (api *HttpApi) ConvertPayload(detectType detector.DetectType, data []byte) (detector.TargetIterator, error) {
+	targets, err := api.converter.convertPayload(detectType, data)
 	if err != nil {
-		ctx.JSON(http.StatusOK, NewCommonResponse(1, err.Error(), nil))
+		return nil, err
+	}
+	if err = api.checkTargets(targets); err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
+
+// checkTargets check number of expanded targets of one request
+func (api *HttpApi) checkTargets(targets detector.TargetIterator) error {
+	if targets.Count() > api.options.MaxDetectTargets {
+		return &QuotaError{Quota: QuotaTargetsPerRequest, Limit: api.options.MaxDetectTargets,
+			Requested: targets.Count()}
+	}
+	return nil
+}
+
+// respondError respond error with status of its kind and details of it as
+// data, status is used for error of unknown kind
+func respondError(ctx *gin.Context, status int, err error) {
+	var (
+		quotaErr      *QuotaError
+		validationErr *ValidationError
+		specErr       *tools.SpecError
+	)
+	switch {
+	case errors.As(err, &quotaErr):
+		if quotaErr.ResetAt != nil {
+			var seconds = int(math.Ceil(time.Until(*quotaErr.ResetAt).Seconds()))
+			if seconds < 1 {
+				seconds = 1
+			}
+			ctx.Header("Retry-After", strconv.Itoa(seconds))
+		}
+		ctx.JSON(http.StatusTooManyRequests, NewCommonResponse(1, err.Error(), quotaErr))
+	case errors.As(err, &validationErr):
+		ctx.JSON(http.StatusBadRequest, NewCommonResponse(1, err.Error(), validationErr))
+	case errors.As(err, &specErr):
+		// malformed target is responded with the bad token
+		ctx.JSON(http.StatusBadRequest, NewCommonResponse(1, err.Error(), specErr))
+	case errors.Is(err, dispatcher.ErrTaskNotFound), errors.Is(err, scheduler.ErrJobNotFound):
+		ctx.JSON(http.StatusNotFound, NewCommonResponse(1, err.Error(), nil))
+	case errors.Is(err, dispatcher.ErrTaskFinished), errors.Is(err, scheduler.ErrJobExists):
+		ctx.JSON(http.StatusConflict, NewCommonResponse(1, err.Error(), nil))
+	case errors.Is(err, scheduler.ErrInvalidJob):
+		ctx.JSON(http.StatusBadRequest, NewCommonResponse(1, err.Error(), nil))
+	default:
+		ctx.JSON(status, NewCommonResponse(1, err.Error(), nil))
+	}
+}
+
+func (api *HttpApi) HandleTaskStatus(ctx *gin.Context) {
+	status, ok := api.tracker.Get(ctx.Param("id"))
+	if !ok {
+		respondError(ctx, http.StatusNotFound, dispatcher.ErrTaskNotFound)
 		return
 	}
 
-	tasks, err := convertPayloadToIcmpTask(payload)
+	ctx.JSON(http.StatusOK, NewCommonResponse(0, "ok", status))
+}
+
+// HandleCancelTask cancel unfinished task, task already finished is
+// responded with conflict
+func (api *HttpApi) HandleCancelTask(ctx *gin.Context) {
+	status, err := api.dispatch.Cancel(ctx.Param("id"))
 	if err != nil {
-		ctx.JSON(http.StatusOK, NewCommonResponse(1, err.Error(), nil))
+		respondError(ctx, http.StatusInternalServerError, err)
 		return
 	}
-	for _, task := range tasks {
-		api.icmpPublisher.Publish() <- task
+	ctx.JSON(http.StatusOK, NewCommonResponse(0, "ok", status))
+}
+
+// HandleTaskResults respond aggregated results of task, per target
+// results are omitted when query targets is false
+func (api *HttpApi) HandleTaskResults(ctx *gin.Context) {
+	var withTargets = ctx.DefaultQuery("targets", "true") != "false"
+	result, ok := api.tracker.Result(ctx.Param("id"), withTargets)
+	if !ok {
+		respondError(ctx, http.StatusNotFound, dispatcher.ErrTaskNotFound)
+		return
 	}
 
-	ctx.JSON(http.StatusOK, NewCommonResponse(0, "ok", nil))
+	ctx.JSON(http.StatusOK, NewCommonResponse(0, "ok", result))
 }
 
 func NewHttpApi(options HttpApiOptions) *HttpApi {
 	var api = &HttpApi{
-		srv:     gin.New(),
-		options: options,
+		srv:       gin.New(),
+		options:   options,
+		converter: payloadConverter{maxHostBits: options.MaxHostBits},
+		quota:     newQuotaManager(options.Quota),
+	}
+	api.server = &http.Server{
+		Addr:    options.Listen,
+		Handler: api.srv,
 	}
+	// client ip identifies client of quota, so forwarded headers of
+	// untrusted peers are ignored
+	if err := api.srv.SetTrustedProxies(options.TrustedProxies); err != nil {
+		log.Logger.Errorf("invalid trusted proxies, no proxy is trusted. %s", err)
+		_ = api.srv.SetTrustedProxies(nil)
+	}
+	api.upgrader = websocket.Upgrader{CheckOrigin: api.checkOrigin}
+
+	// document of api is registered before authentication
+	api.srv.GET("/openapi.json", api.HandleOpenApi)
+	api.srv.Use(api.authenticate)
 
-	var group = api.srv.Group("/detects")
+	var group = api.srv.Group("/detects", api.authorize(RoleSubmit))
+	group.POST("", api.HandleDetect)
 	group.POST("/icmp", api.HandleIcmpDetect)
+	group.POST("/tcp", api.HandleTcpDetect)
+	group.POST("/udp", api.HandleUdpDetect)
+	group.POST("/http", api.HandleHttpDetect)
+
+	var tasks = api.srv.Group("/tasks")
+	tasks.GET("/:id", api.authorize(RoleRead), api.HandleTaskStatus)
+	tasks.DELETE("/:id", api.authorize(RoleSubmit), api.HandleCancelTask)
+	tasks.GET("/:id/results", api.authorize(RoleRead), api.HandleTaskResults)
+	tasks.GET("/:id/stream", api.authorize(RoleRead), api.HandleTaskStream)
+	tasks.GET("/:id/ws", api.authorize(RoleRead), api.HandleTaskWebSocket)
+
+	var jobs = api.srv.Group("/jobs")
+	jobs.POST("", api.authorize(RoleAdmin), api.HandleCreateJob)
+	jobs.GET("", api.authorize(RoleRead), api.HandleListJobs)
+	jobs.GET("/:id", api.authorize(RoleRead), api.HandleGetJob)
+	jobs.PUT("/:id", api.authorize(RoleAdmin), api.HandleUpdateJob)
+	jobs.DELETE("/:id", api.authorize(RoleAdmin), api.HandleDeleteJob)
 
 	return api
 }
 
 func (api *HttpApi) Start() error {
-	if err := api.srv.Run(api.options.Listen); err != nil {
+	if len(api.authenticators) == 0 {
+		log.Logger.Warnf("http api authentication is disabled, all requests are allowed")
+	}
+	if api.options.Tls.Cert == "" {
+		if api.options.Tls.ClientCA != "" {
+			return fmt.Errorf("tls client ca is set without tls cert")
+		}
+		log.Logger.Infof("http api serves on %s", api.options.Listen)
+		return ignoreServerClosed(api.server.ListenAndServe())
+	}
+
+	reloader, err := newCertReloader(api.options.Tls.Cert, api.options.Tls.Key, api.options.Tls.ReloadInterval)
+	if err != nil {
+		return err
+	}
+	defer reloader.close()
+	tlsConfig, err := newTlsConfig(api.options.Tls, reloader)
+	if err != nil {
+		return err
+	}
+	go reloader.watch()
+
+	api.server.TLSConfig = tlsConfig
+	log.Logger.Infof("http api serves tls on %s", api.options.Listen)
+	// certificate is served by tls config
+	return ignoreServerClosed(api.server.ListenAndServeTLS("", ""))
+}
+
+// Stop close listener at once and wait active requests until ctx is done,
+// requests left are closed then
+func (api *HttpApi) Stop(ctx context.Context) error {
+	if err := api.server.Shutdown(ctx); err != nil {
+		_ = api.server.Close()
 		return err
 	}
 	return nil
 }
+
+// ignoreServerClosed server closed by Stop is not an error
+func ignoreServerClosed(err error) error {
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
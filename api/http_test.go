@@ -0,0 +1,185 @@
+package api
+
+import (
+	"detect-server/connector"
+	"detect-server/detector"
+	"detect-server/dispatcher"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHttpApi_DetectWait(t *testing.T) {
+	var slowHit = make(chan struct{}, 10)
+	var mux = http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		slowHit <- struct{}{}
+		<-r.Context().Done()
+	})
+	var server = httptest.NewServer(mux)
+	defer server.Close()
+
+	var httpDetector = detector.NewHttpDetector(detector.HttpDetectorOptions{DefaultTimeout: 5000, DefaultCount: 1,
+		MaxRunnerCount: 2, MaxDetectBufferSize: 1, MaxResultQueueSize: 10})
+	if err := httpDetector.Start(); err != nil {
+		t.Fatalf("start http detector failed. %s", err)
+	}
+	defer httpDetector.Stop()
+	var (
+		dispatch  = dispatcher.NewDispatcher(dispatcher.Options{})
+		tracker   = dispatcher.NewTracker(dispatcher.TrackerOptions{Retention: time.Minute})
+		filter, _ = dispatcher.NewFilter(dispatcher.FilterOptions{})
+		tasks     = connector.NewChanConnector[dispatcher.Task](connector.Options{MaxBufferSize: 10})
+	)
+	dispatch.AddReceiver(tasks)
+	dispatch.AddRoute(dispatcher.NewRoute(detector.HTTPDetect, httpDetector,
+		dispatcher.NewDefaultProcessor[detector.HttpOptions, *detector.HttpStatistics, dispatcher.DefaultMessage]()))
+	dispatch.AddPublisher(connector.NewChanConnector[any](connector.Options{MaxBufferSize: 10}))
+	dispatch.AddTracker(tracker)
+	dispatch.AddBroker(dispatcher.NewBroker(dispatcher.BrokerOptions{SubscriberBufferSize: 10}))
+	dispatch.AddFilter(filter)
+	dispatch.AddLimiter(dispatcher.NewLimiter(dispatcher.LimiterOptions{}))
+	if err := dispatch.Start(); err != nil {
+		t.Fatalf("start dispatcher failed. %s", err)
+	}
+	defer dispatch.Stop()
+
+	var api = NewHttpApi(HttpApiOptions{MaxDetectTargets: 100, MaxHostBits: 16, MaxSyncTargets: 2,
+		SyncTimeout: 300 * time.Millisecond, Quota: QuotaOptions{ConcurrentTasks: 1}})
+	api.AddTracker(tracker)
+	api.AddDispatcher(dispatch)
+	api.AddTaskPublisher(tasks)
+	var detect = func(paths ...string) (int, []map[string]any) {
+		var urls = make([]string, 0, len(paths))
+		for _, path := range paths {
+			urls = append(urls, `"`+server.URL+path+`"`)
+		}
+		var body = `{"targets": [` + strings.Join(urls, ",") + `]}`
+		var recorder = httptest.NewRecorder()
+		api.srv.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/detects/http?wait=true", strings.NewReader(body)))
+		var resp struct {
+			Data []map[string]any `json:"data"`
+		}
+		_ = json.Unmarshal(recorder.Body.Bytes(), &resp)
+		return recorder.Code, resp.Data
+	}
+
+	tests := []struct {
+		name      string
+		paths     []string
+		status    int
+		wantError []bool
+	}{
+		{name: "success", paths: []string{"/ok", "/ok"}, status: http.StatusOK, wantError: []bool{false, false}},
+		{name: "timeout", paths: []string{"/ok", "/slow"}, status: http.StatusOK, wantError: []bool{false, true}},
+		{name: "too many targets", paths: []string{"/ok", "/ok", "/ok"}, status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var start = time.Now()
+			status, messages := detect(tt.paths...)
+			if status != tt.status {
+				t.Fatalf("detect status = %d, want %d", status, tt.status)
+			}
+			if time.Since(start) > time.Second {
+				t.Errorf("detect returned after %s, want within sync timeout", time.Since(start))
+			}
+			if len(messages) != len(tt.wantError) {
+				t.Fatalf("detect results = %v, want %d results", messages, len(tt.wantError))
+			}
+			for i, message := range messages {
+				if _, failed := message["Error"].(string); failed != tt.wantError[i] {
+					t.Errorf("detect result %d = %v, want error %v", i, message, tt.wantError[i])
+				}
+			}
+		})
+	}
+
+	// waiting request holds concurrent task quota of client
+	for len(slowHit) > 0 {
+		<-slowHit
+	}
+	var done = make(chan struct{})
+	go func() {
+		defer close(done)
+		detect("/slow")
+	}()
+	select {
+	case <-slowHit:
+	case <-time.After(time.Second):
+		t.Fatalf("wait slow request timeout")
+	}
+	if status, _ := detect("/ok"); status != http.StatusTooManyRequests {
+		t.Errorf("detect while other request waits status = %d, want %d", status, http.StatusTooManyRequests)
+	}
+	<-done
+	if status, _ := detect("/ok"); status != http.StatusOK {
+		t.Errorf("detect after other request returned status = %d, want %d", status, http.StatusOK)
+	}
+}
+
+func TestHttpApi_SubmitTask(t *testing.T) {
+	var (
+		tracker = dispatcher.NewTracker(dispatcher.TrackerOptions{Retention: time.Minute})
+		tasks   = connector.NewChanConnector[dispatcher.Task](connector.Options{MaxBufferSize: 10})
+		api     = NewHttpApi(HttpApiOptions{MaxDetectTargets: 100, MaxHostBits: 16})
+	)
+	api.AddTracker(tracker)
+	api.AddTaskPublisher(tasks)
+	var recorder = httptest.NewRecorder()
+	api.srv.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/detects/icmp",
+		strings.NewReader(`{"targets": ["127.0.0.1", "127.0.0.2"]}`)))
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("submit status = %d, want %d", recorder.Code, http.StatusAccepted)
+	}
+	var resp struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response failed. %s", err)
+	}
+	// progress is counted while task is dispatched, so it is not replied
+	if resp.Data["total"] != float64(2) || resp.Data["state"] != dispatcher.TaskPending {
+		t.Errorf("submit response = %v, want pending task of 2 targets", resp.Data)
+	}
+	if _, ok := resp.Data["filtered"]; ok {
+		t.Errorf("submit response = %v, want no filtered count", resp.Data)
+	}
+	var task = <-tasks.Receive()
+	if status, ok := tracker.Get(task.Id()); !ok || resp.Data["id"] != task.Id() || status.Total != 2 {
+		t.Errorf("tracked task = %+v, want task of response", status)
+	}
+}
+
+func TestHttpApi_CheckOrigin(t *testing.T) {
+	var api = NewHttpApi(HttpApiOptions{AllowedOrigins: []string{"https://console.example.com/"}})
+	tests := []struct {
+		name   string
+		origin string
+		want   bool
+	}{
+		{name: "no origin", want: true},
+		{name: "same origin", origin: "http://detect.example.com:8080", want: true},
+		{name: "allowed origin", origin: "https://console.example.com", want: true},
+		{name: "allowed host with other scheme", origin: "http://console.example.com"},
+		{name: "cross origin", origin: "https://evil.example.com"},
+		{name: "invalid origin", origin: "://"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req = httptest.NewRequest(http.MethodGet, "http://detect.example.com:8080/tasks/1/ws", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if got := api.checkOrigin(req); got != tt.want {
+				t.Errorf("checkOrigin() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"detect-server/scheduler"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+func (api *HttpApi) AddScheduler(scheduler scheduler.Scheduler) {
+	api.scheduler = scheduler
+}
+
+func (api *HttpApi) HandleCreateJob(ctx *gin.Context) {
+	var job = scheduler.Job{}
+	if err := decodePayload(ctx.Request.Body, &job); err != nil {
+		respondError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	job.Id = ""
+	created, err := api.scheduler.Create(job)
+	if err != nil {
+		respondError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, NewCommonResponse(0, "ok", created))
+}
+
+func (api *HttpApi) HandleListJobs(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, NewCommonResponse(0, "ok", api.scheduler.List()))
+}
+
+func (api *HttpApi) HandleGetJob(ctx *gin.Context) {
+	job, ok := api.scheduler.Get(ctx.Param("id"))
+	if !ok {
+		respondError(ctx, http.StatusNotFound, scheduler.ErrJobNotFound)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewCommonResponse(0, "ok", job))
+}
+
+func (api *HttpApi) HandleUpdateJob(ctx *gin.Context) {
+	var job = scheduler.Job{}
+	if err := decodePayload(ctx.Request.Body, &job); err != nil {
+		respondError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	updated, err := api.scheduler.Update(ctx.Param("id"), job)
+	if err != nil {
+		respondError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewCommonResponse(0, "ok", updated))
+}
+
+func (api *HttpApi) HandleDeleteJob(ctx *gin.Context) {
+	if err := api.scheduler.Delete(ctx.Param("id")); err != nil {
+		respondError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewCommonResponse(0, "ok", nil))
+}
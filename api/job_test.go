@@ -0,0 +1,62 @@
+package api
+
+import (
+	"detect-server/connector"
+	"detect-server/dispatcher"
+	"detect-server/scheduler"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHttpApi_Jobs(t *testing.T) {
+	var api = NewHttpApi(HttpApiOptions{MaxDetectTargets: 100, MaxHostBits: 16})
+	var jobScheduler = scheduler.NewScheduler()
+	jobScheduler.AddPublisher(connector.NewChanConnector[dispatcher.Task](connector.Options{MaxBufferSize: 10}))
+	jobScheduler.AddTracker(dispatcher.NewTracker(dispatcher.TrackerOptions{Retention: time.Minute}))
+	jobScheduler.AddBuilder(api.ConvertPayload)
+	if err := jobScheduler.Start(); err != nil {
+		t.Fatalf("start scheduler failed. %s", err)
+	}
+	defer jobScheduler.Stop()
+	api.AddScheduler(jobScheduler)
+
+	var request = func(method string, path string, body string) (int, scheduler.Job) {
+		var recorder = httptest.NewRecorder()
+		api.srv.ServeHTTP(recorder, httptest.NewRequest(method, path, strings.NewReader(body)))
+		var resp struct {
+			Data scheduler.Job `json:"data"`
+		}
+		_ = json.Unmarshal(recorder.Body.Bytes(), &resp)
+		return recorder.Code, resp.Data
+	}
+
+	// run statistics written by client are ignored
+	status, job := request(http.MethodPost, "/jobs", `{"name": "ping", "type": "icmp", "interval": 60,
+		"payload": {"targets": ["127.0.0.1"]}, "runs": 10, "lastTaskId": "forged", "createdAt": "2000-01-01T00:00:00Z"}`)
+	if status != http.StatusCreated || job.Id == "" || job.Runs != 0 || job.LastTaskId != "" || job.CreatedAt.Year() == 2000 {
+		t.Fatalf("create job = %d %+v, want created without run statistics", status, job)
+	}
+	if status, _ = request(http.MethodPost, "/jobs", `{"name": "ping", "type": "icmp", "interval": 60,
+		"payload": {"targets": ["10.0.0.1-x"]}}`); status != http.StatusBadRequest {
+		t.Errorf("create job with invalid payload = %d, want %d", status, http.StatusBadRequest)
+	}
+
+	status, updated := request(http.MethodPut, "/jobs/"+job.Id, `{"name": "renamed", "type": "icmp", "cron": "@hourly",
+		"payload": {"targets": ["127.0.0.1"]}, "runs": 10}`)
+	if status != http.StatusOK || updated.Name != "renamed" || updated.Runs != 0 || !updated.CreatedAt.Equal(job.CreatedAt) {
+		t.Errorf("update job = %d %+v, want renamed job keeping run statistics", status, updated)
+	}
+	if status, got := request(http.MethodGet, "/jobs/"+job.Id, ""); status != http.StatusOK || got.Name != "renamed" {
+		t.Errorf("get job = %d %+v, want renamed job", status, got)
+	}
+	if status, _ = request(http.MethodDelete, "/jobs/"+job.Id, ""); status != http.StatusOK {
+		t.Errorf("delete job = %d, want %d", status, http.StatusOK)
+	}
+	if status, _ = request(http.MethodGet, "/jobs/"+job.Id, ""); status != http.StatusNotFound {
+		t.Errorf("get deleted job = %d, want %d", status, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,18 @@
+package api
+
+import (
+	_ "embed"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// openApiSpec OpenAPI document of http api, keep it in sync with routes and
+// binding tags of payloads
+//
+//go:embed openapi.json
+var openApiSpec []byte
+
+// HandleOpenApi serve OpenAPI document, it is public without authentication
+func (api *HttpApi) HandleOpenApi(ctx *gin.Context) {
+	ctx.Data(http.StatusOK, "application/json; charset=utf-8", openApiSpec)
+}
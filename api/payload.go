@@ -0,0 +1,254 @@
+package api
+
+import (
+	"bytes"
+	"detect-server/detector"
+	"detect-server/tools"
+	"fmt"
+	"strings"
+)
+
+// IcmpDetectPayload targets are specifications parsed by tools.ParseTargetSpec,
+// Type subnet is not required any more and kept for compatibility
+type IcmpDetectPayload struct {
+	Timeout int      `json:"timeout" binding:"gte=0,lte=60000"`
+	Count   int      `json:"count" binding:"gte=0,lte=100"`
+	Type    string   `json:"type" binding:"omitempty,oneof=subnet"`
+	Targets []string `json:"targets" binding:"required,dive,required"`
+}
+
+type TcpDetectPayload struct {
+	Timeout int      `json:"timeout" binding:"gte=0,lte=60000"`
+	Count   int      `json:"count" binding:"gte=0,lte=100"`
+	Type    string   `json:"type" binding:"omitempty,oneof=subnet"`
+	Ports   []int    `json:"ports" binding:"required,dive,min=1,max=65535"`
+	Targets []string `json:"targets" binding:"required,dive,required"`
+}
+
+// UdpDetectPayload Payload is hex encoded, Template is one of dns, ntp, snmp,
+// one of them is required since most services never reply empty datagram
+type UdpDetectPayload struct {
+	Timeout  int      `json:"timeout" binding:"gte=0,lte=60000"`
+	Count    int      `json:"count" binding:"gte=0,lte=100"`
+	Type     string   `json:"type" binding:"omitempty,oneof=subnet"`
+	Ports    []int    `json:"ports" binding:"omitempty,dive,min=1,max=65535"`
+	Payload  string   `json:"payload" binding:"omitempty,hexadecimal"`
+	Template string   `json:"template"`
+	Targets  []string `json:"targets" binding:"required,dive,required"`
+}
+
+// HttpDetectPayload targets are http or https urls, url without scheme is
+// detected by http, ExpectedStatus empty means any status
+// less than 400 is accepted
+type HttpDetectPayload struct {
+	Timeout         int               `json:"timeout" binding:"gte=0,lte=60000"`
+	Count           int               `json:"count" binding:"gte=0,lte=100"`
+	Method          string            `json:"method"`
+	Headers         map[string]string `json:"headers"`
+	Body            string            `json:"body"`
+	ExpectedStatus  []int             `json:"expectedStatus" binding:"omitempty,dive,min=100,max=599"`
+	BodyRegex       string            `json:"bodyRegex"`
+	BodyContains    string            `json:"bodyContains"`
+	FollowRedirects bool              `json:"followRedirects"`
+	MaxRedirects    int               `json:"maxRedirects" binding:"gte=0,lte=100"`
+	Insecure        bool              `json:"insecure"`
+	Targets         []string          `json:"targets" binding:"required,dive,httpurl"`
+}
+
+// DetectPayload mix checks of many protocols in one task
+type DetectPayload struct {
+	Icmp *IcmpDetectPayload `json:"icmp"`
+	Tcp  *TcpDetectPayload  `json:"tcp"`
+	Udp  *UdpDetectPayload  `json:"udp"`
+	Http *HttpDetectPayload `json:"http"`
+}
+
+// specIterator create target of every ip or hostname in specifications lazily
+type specIterator[T detector.DetectInput] struct {
+	detectType detector.DetectType
+	targets    *tools.SpecIterator
+	options    detector.DetectOptions[T]
+}
+
+func (iterator *specIterator[T]) Next() (detector.Target, bool) {
+	target, ok := iterator.targets.Next()
+	if !ok {
+		return nil, false
+	}
+	return detector.NewDetectTarget(iterator.detectType, target, iterator.options), true
+}
+
+func (iterator *specIterator[T]) Count() int {
+	return iterator.targets.Count()
+}
+
+// payloadConverter convert payloads of detect api to targets, network or
+// range with more than 2^maxHostBits ips is rejected
+type payloadConverter struct {
+	maxHostBits int
+}
+
+// convertTargets convert target specifications to detect targets, see
+// tools.TargetSpec for the syntax. subnet type is kept for compatibility,
+// cidr is expanded without it
+func convertTargets[T detector.DetectInput](converter payloadConverter, detectType detector.DetectType,
+	targets []string, options detector.DetectOptions[T]) (detector.TargetIterator, error) {
+	spec, err := tools.ParseTargetSpec(targets, converter.maxHostBits)
+	if err != nil {
+		return nil, err
+	}
+	return &specIterator[T]{detectType: detectType, targets: spec.Iterator(), options: options}, nil
+}
+
+func (converter payloadConverter) convertPayloadToIcmpTargets(payload IcmpDetectPayload) (detector.TargetIterator, error) {
+	var options = detector.DetectOptions[detector.IcmpOptions]{
+		Count:   payload.Count,
+		Timeout: payload.Timeout,
+	}
+	return convertTargets(converter, detector.ICMPDetect, payload.Targets, options)
+}
+
+func (converter payloadConverter) convertPayloadToTcpTargets(payload TcpDetectPayload) (detector.TargetIterator, error) {
+	if len(payload.Ports) == 0 {
+		return nil, fmt.Errorf("ports can not be empty")
+	}
+	for _, port := range payload.Ports {
+		if port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("invalid port %d", port)
+		}
+	}
+	var options = detector.DetectOptions[detector.TcpOptions]{
+		Count:   payload.Count,
+		Timeout: payload.Timeout,
+		Options: detector.TcpOptions{Ports: payload.Ports},
+	}
+	return convertTargets(converter, detector.TCPDetect, payload.Targets, options)
+}
+
+func (converter payloadConverter) convertPayloadToUdpTargets(payload UdpDetectPayload) (detector.TargetIterator, error) {
+	var udpOptions = detector.UdpOptions{
+		Ports:    payload.Ports,
+		Payload:  payload.Payload,
+		Template: payload.Template,
+	}
+	if payload.Payload == "" && payload.Template == "" {
+		return nil, fmt.Errorf("payload or template is required")
+	}
+	if _, err := udpOptions.BuildPayload(); err != nil {
+		return nil, err
+	}
+	if len(udpOptions.DetectPorts()) == 0 {
+		return nil, fmt.Errorf("ports can not be empty without template")
+	}
+	for _, port := range udpOptions.Ports {
+		if port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("invalid port %d", port)
+		}
+	}
+	var options = detector.DetectOptions[detector.UdpOptions]{
+		Count:   payload.Count,
+		Timeout: payload.Timeout,
+		Options: udpOptions,
+	}
+	return convertTargets(converter, detector.UDPDetect, payload.Targets, options)
+}
+
+func (converter payloadConverter) convertPayloadToHttpTargets(payload HttpDetectPayload) (detector.TargetIterator, error) {
+	var httpOptions = detector.HttpOptions{
+		Method:          strings.ToUpper(payload.Method),
+		Headers:         payload.Headers,
+		Body:            payload.Body,
+		ExpectedStatus:  payload.ExpectedStatus,
+		BodyRegex:       payload.BodyRegex,
+		BodyContains:    payload.BodyContains,
+		FollowRedirects: payload.FollowRedirects,
+		MaxRedirects:    payload.MaxRedirects,
+		Insecure:        payload.Insecure,
+	}
+	if err := httpOptions.Validate(); err != nil {
+		return nil, err
+	}
+	var options = detector.DetectOptions[detector.HttpOptions]{
+		Count:   payload.Count,
+		Timeout: payload.Timeout,
+		Options: httpOptions,
+	}
+	// targets of http are urls
+	var detects = make([]detector.Target, 0, len(payload.Targets))
+	for _, target := range payload.Targets {
+		detects = append(detects, detector.NewDetectTarget(detector.HTTPDetect, target, options))
+	}
+	return detector.NewSliceIterator(detects...), nil
+}
+
+// convertPayloadToTargets convert targets of all protocols in payload
+func (converter payloadConverter) convertPayloadToTargets(payload DetectPayload) (detector.TargetIterator, error) {
+	var iterators = make([]detector.TargetIterator, 0)
+	var converts = []func() (detector.TargetIterator, error){
+		func() (detector.TargetIterator, error) {
+			if payload.Icmp == nil {
+				return nil, nil
+			}
+			return converter.convertPayloadToIcmpTargets(*payload.Icmp)
+		},
+		func() (detector.TargetIterator, error) {
+			if payload.Tcp == nil {
+				return nil, nil
+			}
+			return converter.convertPayloadToTcpTargets(*payload.Tcp)
+		},
+		func() (detector.TargetIterator, error) {
+			if payload.Udp == nil {
+				return nil, nil
+			}
+			return converter.convertPayloadToUdpTargets(*payload.Udp)
+		},
+		func() (detector.TargetIterator, error) {
+			if payload.Http == nil {
+				return nil, nil
+			}
+			return converter.convertPayloadToHttpTargets(*payload.Http)
+		},
+	}
+	for _, convert := range converts {
+		detects, err := convert()
+		if err != nil {
+			return nil, err
+		}
+		if detects != nil {
+			iterators = append(iterators, detects)
+		}
+	}
+	var targets = detector.ChainIterators(iterators...)
+	if targets.Count() == 0 {
+		return nil, fmt.Errorf("targets can not be empty")
+	}
+	return targets, nil
+}
+
+// convertPayload convert json payload of detect api to targets, empty
+// detect type means mixed DetectPayload
+func (converter payloadConverter) convertPayload(detectType detector.DetectType, data []byte) (detector.TargetIterator, error) {
+	switch detectType {
+	case "":
+		return unmarshalAndConvert(data, converter.convertPayloadToTargets)
+	case detector.ICMPDetect:
+		return unmarshalAndConvert(data, converter.convertPayloadToIcmpTargets)
+	case detector.TCPDetect:
+		return unmarshalAndConvert(data, converter.convertPayloadToTcpTargets)
+	case detector.UDPDetect:
+		return unmarshalAndConvert(data, converter.convertPayloadToUdpTargets)
+	case detector.HTTPDetect:
+		return unmarshalAndConvert(data, converter.convertPayloadToHttpTargets)
+	default:
+		return nil, fmt.Errorf("unknown detect type %s", detectType)
+	}
+}
+
+func unmarshalAndConvert[P any](data []byte, convert func(P) (detector.TargetIterator, error)) (detector.TargetIterator, error) {
+	var payload P
+	if err := decodePayload(bytes.NewReader(data), &payload); err != nil {
+		return nil, err
+	}
+	return convert(payload)
+}
@@ -0,0 +1,338 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: detect.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	DetectService_SubmitTask_FullMethodName    = "/detect.v1.DetectService/SubmitTask"
+	DetectService_Detect_FullMethodName        = "/detect.v1.DetectService/Detect"
+	DetectService_StreamResults_FullMethodName = "/detect.v1.DetectService/StreamResults"
+	DetectService_GetTask_FullMethodName       = "/detect.v1.DetectService/GetTask"
+	DetectService_GetTaskResult_FullMethodName = "/detect.v1.DetectService/GetTaskResult"
+	DetectService_CancelTask_FullMethodName    = "/detect.v1.DetectService/CancelTask"
+)
+
+// DetectServiceClient is the client API for DetectService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type DetectServiceClient interface {
+	// SubmitTask publish targets as one task and return its status, the
+	// task is pending so its progress such as filtered is always zero, see
+	// GetTask for progress
+	SubmitTask(ctx context.Context, in *DetectRequest, opts ...grpc.CallOption) (*TaskStatus, error)
+	// Detect detect targets and wait results, targets are limited by
+	// sync max targets of http api
+	Detect(ctx context.Context, in *DetectRequest, opts ...grpc.CallOption) (*DetectResponse, error)
+	// StreamResults stream result of every target until the task is completed
+	StreamResults(ctx context.Context, in *TaskRequest, opts ...grpc.CallOption) (DetectService_StreamResultsClient, error)
+	GetTask(ctx context.Context, in *TaskRequest, opts ...grpc.CallOption) (*TaskStatus, error)
+	GetTaskResult(ctx context.Context, in *TaskResultRequest, opts ...grpc.CallOption) (*TaskResult, error)
+	// CancelTask cancel unfinished task, queued targets are skipped and
+	// targets being detected are aborted
+	CancelTask(ctx context.Context, in *TaskRequest, opts ...grpc.CallOption) (*TaskStatus, error)
+}
+
+type detectServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDetectServiceClient(cc grpc.ClientConnInterface) DetectServiceClient {
+	return &detectServiceClient{cc}
+}
+
+func (c *detectServiceClient) SubmitTask(ctx context.Context, in *DetectRequest, opts ...grpc.CallOption) (*TaskStatus, error) {
+	out := new(TaskStatus)
+	err := c.cc.Invoke(ctx, DetectService_SubmitTask_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *detectServiceClient) Detect(ctx context.Context, in *DetectRequest, opts ...grpc.CallOption) (*DetectResponse, error) {
+	out := new(DetectResponse)
+	err := c.cc.Invoke(ctx, DetectService_Detect_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *detectServiceClient) StreamResults(ctx context.Context, in *TaskRequest, opts ...grpc.CallOption) (DetectService_StreamResultsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &DetectService_ServiceDesc.Streams[0], DetectService_StreamResults_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &detectServiceStreamResultsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type DetectService_StreamResultsClient interface {
+	Recv() (*TaskEvent, error)
+	grpc.ClientStream
+}
+
+type detectServiceStreamResultsClient struct {
+	grpc.ClientStream
+}
+
+func (x *detectServiceStreamResultsClient) Recv() (*TaskEvent, error) {
+	m := new(TaskEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *detectServiceClient) GetTask(ctx context.Context, in *TaskRequest, opts ...grpc.CallOption) (*TaskStatus, error) {
+	out := new(TaskStatus)
+	err := c.cc.Invoke(ctx, DetectService_GetTask_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *detectServiceClient) GetTaskResult(ctx context.Context, in *TaskResultRequest, opts ...grpc.CallOption) (*TaskResult, error) {
+	out := new(TaskResult)
+	err := c.cc.Invoke(ctx, DetectService_GetTaskResult_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *detectServiceClient) CancelTask(ctx context.Context, in *TaskRequest, opts ...grpc.CallOption) (*TaskStatus, error) {
+	out := new(TaskStatus)
+	err := c.cc.Invoke(ctx, DetectService_CancelTask_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DetectServiceServer is the server API for DetectService service.
+// All implementations must embed UnimplementedDetectServiceServer
+// for forward compatibility
+type DetectServiceServer interface {
+	// SubmitTask publish targets as one task and return its status, the
+	// task is pending so its progress such as filtered is always zero, see
+	// GetTask for progress
+	SubmitTask(context.Context, *DetectRequest) (*TaskStatus, error)
+	// Detect detect targets and wait results, targets are limited by
+	// sync max targets of http api
+	Detect(context.Context, *DetectRequest) (*DetectResponse, error)
+	// StreamResults stream result of every target until the task is completed
+	StreamResults(*TaskRequest, DetectService_StreamResultsServer) error
+	GetTask(context.Context, *TaskRequest) (*TaskStatus, error)
+	GetTaskResult(context.Context, *TaskResultRequest) (*TaskResult, error)
+	// CancelTask cancel unfinished task, queued targets are skipped and
+	// targets being detected are aborted
+	CancelTask(context.Context, *TaskRequest) (*TaskStatus, error)
+	mustEmbedUnimplementedDetectServiceServer()
+}
+
+// UnimplementedDetectServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedDetectServiceServer struct {
+}
+
+func (UnimplementedDetectServiceServer) SubmitTask(context.Context, *DetectRequest) (*TaskStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SubmitTask not implemented")
+}
+func (UnimplementedDetectServiceServer) Detect(context.Context, *DetectRequest) (*DetectResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Detect not implemented")
+}
+func (UnimplementedDetectServiceServer) StreamResults(*TaskRequest, DetectService_StreamResultsServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamResults not implemented")
+}
+func (UnimplementedDetectServiceServer) GetTask(context.Context, *TaskRequest) (*TaskStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTask not implemented")
+}
+func (UnimplementedDetectServiceServer) GetTaskResult(context.Context, *TaskResultRequest) (*TaskResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTaskResult not implemented")
+}
+func (UnimplementedDetectServiceServer) CancelTask(context.Context, *TaskRequest) (*TaskStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelTask not implemented")
+}
+func (UnimplementedDetectServiceServer) mustEmbedUnimplementedDetectServiceServer() {}
+
+// UnsafeDetectServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DetectServiceServer will
+// result in compilation errors.
+type UnsafeDetectServiceServer interface {
+	mustEmbedUnimplementedDetectServiceServer()
+}
+
+func RegisterDetectServiceServer(s grpc.ServiceRegistrar, srv DetectServiceServer) {
+	s.RegisterService(&DetectService_ServiceDesc, srv)
+}
+
+func _DetectService_SubmitTask_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DetectRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DetectServiceServer).SubmitTask(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DetectService_SubmitTask_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DetectServiceServer).SubmitTask(ctx, req.(*DetectRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DetectService_Detect_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DetectRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DetectServiceServer).Detect(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DetectService_Detect_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DetectServiceServer).Detect(ctx, req.(*DetectRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DetectService_StreamResults_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(TaskRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DetectServiceServer).StreamResults(m, &detectServiceStreamResultsServer{stream})
+}
+
+type DetectService_StreamResultsServer interface {
+	Send(*TaskEvent) error
+	grpc.ServerStream
+}
+
+type detectServiceStreamResultsServer struct {
+	grpc.ServerStream
+}
+
+func (x *detectServiceStreamResultsServer) Send(m *TaskEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _DetectService_GetTask_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TaskRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DetectServiceServer).GetTask(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DetectService_GetTask_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DetectServiceServer).GetTask(ctx, req.(*TaskRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DetectService_GetTaskResult_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TaskResultRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DetectServiceServer).GetTaskResult(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DetectService_GetTaskResult_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DetectServiceServer).GetTaskResult(ctx, req.(*TaskResultRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DetectService_CancelTask_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TaskRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DetectServiceServer).CancelTask(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DetectService_CancelTask_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DetectServiceServer).CancelTask(ctx, req.(*TaskRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// DetectService_ServiceDesc is the grpc.ServiceDesc for DetectService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var DetectService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "detect.v1.DetectService",
+	HandlerType: (*DetectServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "SubmitTask",
+			Handler:    _DetectService_SubmitTask_Handler,
+		},
+		{
+			MethodName: "Detect",
+			Handler:    _DetectService_Detect_Handler,
+		},
+		{
+			MethodName: "GetTask",
+			Handler:    _DetectService_GetTask_Handler,
+		},
+		{
+			MethodName: "GetTaskResult",
+			Handler:    _DetectService_GetTaskResult_Handler,
+		},
+		{
+			MethodName: "CancelTask",
+			Handler:    _DetectService_CancelTask_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamResults",
+			Handler:       _DetectService_StreamResults_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "detect.proto",
+}
@@ -0,0 +1,4 @@
+// Package pb grpc service of detect api generated from detect.proto
+package pb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative detect.proto
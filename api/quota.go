@@ -0,0 +1,170 @@
+package api
+
+import (
+	"detect-server/dispatcher"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"sync"
+	"time"
+)
+
+const (
+	QuotaTargetsPerRequest = "targetsPerRequest"
+	QuotaTargetsPerMinute  = "targetsPerMinute"
+	QuotaConcurrentTasks   = "concurrentTasks"
+
+	// ApiKeyHeader header of api key
+	ApiKeyHeader = "X-API-Key"
+
+	quotaWindow = time.Minute
+)
+
+// QuotaOptions limit of every client, limit <= 0 means no limit
+type QuotaOptions struct {
+	TargetsPerMinute int
+	ConcurrentTasks  int
+}
+
+func NewQuotaOptions() QuotaOptions {
+	return QuotaOptions{
+		TargetsPerMinute: viper.GetInt("api.http.quota.targetsPerMinute"),
+		ConcurrentTasks:  viper.GetInt("api.http.quota.concurrentTasks"),
+	}
+}
+
+// QuotaError request exceeds quota of client, ResetAt is when the quota
+// is available again if it is known
+type QuotaError struct {
+	Client    string     `json:"client,omitempty"`
+	Quota     string     `json:"quota"`
+	Limit     int        `json:"limit"`
+	Used      int        `json:"used"`
+	Requested int        `json:"requested"`
+	ResetAt   *time.Time `json:"resetAt,omitempty"`
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("quota %s exceeded, limit %d, used %d, requested %d", e.Quota, e.Limit, e.Used, e.Requested)
+}
+
+type clientQuota struct {
+	windowStart time.Time
+	targets     int
+	tasks       []string
+	// held tasks count as running until they are released, whether the
+	// tracker knows them or not
+	held map[string]bool
+}
+
+// quotaManager count targets in fixed window of a minute and unfinished
+// tasks of every client
+type quotaManager struct {
+	options   QuotaOptions
+	tracker   dispatcher.Tracker
+	lock      sync.Mutex
+	clients   map[string]*clientQuota
+	lastSweep time.Time
+}
+
+func newQuotaManager(options QuotaOptions) *quotaManager {
+	return &quotaManager{
+		options:   options,
+		clients:   make(map[string]*clientQuota),
+		lastSweep: time.Now(),
+	}
+}
+
+// acquire consume quota of targets, taskId is recorded as running task
+// of client if it is not empty and held until release. nothing is
+// consumed if any quota is exceeded
+func (manager *quotaManager) acquire(client string, targets int, taskId string) error {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	var now = time.Now()
+	manager.sweep(now)
+	var quota, ok = manager.clients[client]
+	if !ok {
+		quota = &clientQuota{windowStart: now, held: make(map[string]bool)}
+		manager.clients[client] = quota
+	}
+	if now.Sub(quota.windowStart) >= quotaWindow {
+		quota.windowStart = now
+		quota.targets = 0
+	}
+
+	if limit := manager.options.TargetsPerMinute; limit > 0 && quota.targets+targets > limit {
+		var resetAt = quota.windowStart.Add(quotaWindow)
+		return &QuotaError{Client: client, Quota: QuotaTargetsPerMinute, Limit: limit,
+			Used: quota.targets, Requested: targets, ResetAt: &resetAt}
+	}
+	if taskId != "" && manager.options.ConcurrentTasks > 0 {
+		manager.prune(quota)
+		if len(quota.tasks) >= manager.options.ConcurrentTasks {
+			return &QuotaError{Client: client, Quota: QuotaConcurrentTasks, Limit: manager.options.ConcurrentTasks,
+				Used: len(quota.tasks), Requested: 1}
+		}
+	}
+
+	quota.targets += targets
+	if taskId != "" && manager.options.ConcurrentTasks > 0 {
+		quota.tasks = append(quota.tasks, taskId)
+		quota.held[taskId] = true
+	}
+	return nil
+}
+
+// release stop holding task, it counts as running while tracker reports
+// it unfinished
+func (manager *quotaManager) release(client string, taskId string) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	if quota, ok := manager.clients[client]; ok {
+		delete(quota.held, taskId)
+	}
+}
+
+// prune remove finished tasks not held, task not found is expired by
+// tracker
+func (manager *quotaManager) prune(quota *clientQuota) {
+	var running = quota.tasks[:0]
+	for _, id := range quota.tasks {
+		if quota.held[id] {
+			running = append(running, id)
+			continue
+		}
+		status, ok := manager.tracker.Get(id)
+		if ok && !status.Finished() {
+			running = append(running, id)
+		}
+	}
+	quota.tasks = running
+}
+
+// sweep remove clients without quota used, caller must hold the lock
+func (manager *quotaManager) sweep(now time.Time) {
+	if now.Sub(manager.lastSweep) < quotaWindow {
+		return
+	}
+	for client, quota := range manager.clients {
+		if len(quota.tasks) > 0 {
+			manager.prune(quota)
+		}
+		if len(quota.tasks) == 0 && now.Sub(quota.windowStart) >= quotaWindow {
+			delete(manager.clients, client)
+		}
+	}
+	manager.lastSweep = now
+}
+
+// clientKey identify client by authenticated principal or source ip, api
+// key not authenticated is ignored, otherwise clients can get fresh quota
+// by sending random keys
+func clientKey(ctx *gin.Context) string {
+	if principal := principalOf(ctx); principal != nil {
+		return principal.Method + ":" + principal.Name
+	}
+	return "ip:" + ctx.ClientIP()
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"detect-server/connector"
+	"detect-server/detector"
+	"detect-server/dispatcher"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQuotaManager_Acquire(t *testing.T) {
+	var tracker = dispatcher.NewTracker(dispatcher.TrackerOptions{Retention: time.Minute})
+	var manager = newQuotaManager(QuotaOptions{TargetsPerMinute: 10, ConcurrentTasks: 1})
+	manager.tracker = tracker
+
+	var task = dispatcher.NewTask("quota", dispatcher.PriorityNormal, detector.NewSliceIterator(
+		detector.NewDetectTarget(detector.ICMPDetect, "10.0.0.1", detector.DetectOptions[detector.IcmpOptions]{})))
+	tracker.Track(task)
+	var quotaErr *QuotaError
+
+	if err := manager.acquire("a", 6, task.Id()); err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+	manager.release("a", task.Id())
+	if err := manager.acquire("a", 1, "next"); !errors.As(err, &quotaErr) || quotaErr.Quota != QuotaConcurrentTasks {
+		t.Errorf("acquire() error = %v, want concurrent tasks exceeded", err)
+	}
+	if err := manager.acquire("a", 5, ""); !errors.As(err, &quotaErr) || quotaErr.Quota != QuotaTargetsPerMinute ||
+		quotaErr.Used != 6 || quotaErr.ResetAt == nil {
+		t.Errorf("acquire() error = %v, want targets per minute exceeded", err)
+	}
+	if err := manager.acquire("b", 10, ""); err != nil {
+		t.Errorf("acquire() of other client error = %v", err)
+	}
+
+	// finished task no longer counts as concurrent
+	tracker.Start(task.Id())
+	tracker.Done(dispatcher.DefaultMessage{TaskId: task.Id(), Target: "10.0.0.1"})
+	if err := manager.acquire("a", 4, "next"); err != nil {
+		t.Errorf("acquire() after task finished error = %v", err)
+	}
+}
+
+func TestHttpApi_SubmitTaskConcurrent(t *testing.T) {
+	const limit, submits = 2, 20
+	var httpApi = NewHttpApi(HttpApiOptions{MaxDetectTargets: 100, MaxHostBits: 16,
+		Quota: QuotaOptions{ConcurrentTasks: limit}})
+	var tracker = dispatcher.NewTracker(dispatcher.TrackerOptions{Retention: time.Minute})
+	httpApi.AddTracker(tracker)
+	httpApi.AddTaskPublisher(connector.NewChanConnector[dispatcher.Task](connector.Options{MaxBufferSize: submits}))
+
+	// tasks being submitted count before tracker knows them
+	var (
+		wait     sync.WaitGroup
+		lock     sync.Mutex
+		accepted int
+	)
+	for i := 0; i < submits; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			var targets = detector.NewSliceIterator(
+				detector.NewDetectTarget(detector.ICMPDetect, "10.0.0.1", detector.DetectOptions[detector.IcmpOptions]{}))
+			if _, err := httpApi.submitTask("a", "quota", dispatcher.PriorityNormal, targets); err == nil {
+				lock.Lock()
+				accepted++
+				lock.Unlock()
+			}
+		}()
+	}
+	wait.Wait()
+	if accepted != limit {
+		t.Errorf("submitTask() accepted %d concurrent tasks, want %d", accepted, limit)
+	}
+	if unfinished := tracker.Unfinished(); len(unfinished) != limit {
+		t.Errorf("tracked %d tasks, want %d", len(unfinished), limit)
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	var principal = &Principal{Name: "submitter", Method: "key"}
+	tests := []struct {
+		name      string
+		key       string
+		forwarded string
+		trusted   []string
+		principal *Principal
+		want      string
+		// wantGrpc is want if it is empty, grpc has no forwarded header
+		wantGrpc string
+	}{
+		{name: "authenticated", key: "submit-key", principal: principal, want: "key:submitter"},
+		// key not authenticated must not give client fresh quota
+		{name: "unauthenticated key", key: "random-key", want: "ip:192.0.2.1"},
+		{name: "no key", want: "ip:192.0.2.1"},
+		// forwarded header of untrusted peer must not give client fresh quota
+		{name: "spoofed forwarded", forwarded: "1.1.1.1", want: "ip:192.0.2.1"},
+		{name: "trusted proxy", forwarded: "198.51.100.7", trusted: []string{"192.0.2.0/24"},
+			want: "ip:198.51.100.7", wantGrpc: "ip:192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var api = NewHttpApi(HttpApiOptions{TrustedProxies: tt.trusted})
+			var ctx = gin.CreateTestContextOnly(httptest.NewRecorder(), api.srv)
+			ctx.Request = httptest.NewRequest(http.MethodPost, "/detects", nil)
+			ctx.Request.RemoteAddr = "192.0.2.1:40000"
+			if tt.key != "" {
+				ctx.Request.Header.Set(ApiKeyHeader, tt.key)
+			}
+			if tt.forwarded != "" {
+				ctx.Request.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if tt.principal != nil {
+				ctx.Set(principalKey, tt.principal)
+			}
+			if got := clientKey(ctx); got != tt.want {
+				t.Errorf("clientKey() = %s, want %s", got, tt.want)
+			}
+			if tt.wantGrpc == "" {
+				tt.wantGrpc = tt.want
+			}
+
+			var grpcCtx = peer.NewContext(context.Background(),
+				&peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 40000}})
+			if tt.key != "" {
+				grpcCtx = metadata.NewIncomingContext(grpcCtx, metadata.Pairs(ApiKeyHeader, tt.key))
+			}
+			if tt.principal != nil {
+				grpcCtx = context.WithValue(grpcCtx, principalContextKey{}, tt.principal)
+			}
+			if got := grpcClientKey(grpcCtx); got != tt.wantGrpc {
+				t.Errorf("grpcClientKey() = %s, want %s", got, tt.wantGrpc)
+			}
+		})
+	}
+}
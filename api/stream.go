@@ -0,0 +1,143 @@
+package api
+
+import (
+	"detect-server/dispatcher"
+	"detect-server/log"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// streamKeepAlive interval of keepalive sent to idle stream client
+const streamKeepAlive = 15 * time.Second
+
+// checkOrigin allow websocket of request without origin, which is not
+// sent by browser, or origin of api itself or in AllowedOrigins. browser
+// sends credentials of api with cross site websocket, so pages of other
+// origins could read events of tasks in the name of user
+func (api *HttpApi) checkOrigin(r *http.Request) bool {
+	var origin = r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range api.options.AllowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// subscribeTask subscribe events of task, events of finished task only
+// contain the CompletedEvent
+func (api *HttpApi) subscribeTask(id string) (<-chan dispatcher.StreamEvent, func(), bool) {
+	// subscribe before checking status, so completion between them is not lost
+	events, cancel := api.broker.Subscribe(id)
+	status, ok := api.tracker.Get(id)
+	if !ok {
+		cancel()
+		return nil, nil, false
+	}
+	if status.Finished() {
+		cancel()
+		result, _ := api.tracker.Result(id, false)
+		var finished = make(chan dispatcher.StreamEvent, 1)
+		finished <- dispatcher.StreamEvent{Event: dispatcher.CompletedEvent, Data: result}
+		close(finished)
+		return finished, func() {}, true
+	}
+	return events, cancel, true
+}
+
+// HandleTaskStream push events of task as server-sent events
+func (api *HttpApi) HandleTaskStream(ctx *gin.Context) {
+	events, cancel, ok := api.subscribeTask(ctx.Param("id"))
+	if !ok {
+		respondError(ctx, http.StatusNotFound, dispatcher.ErrTaskNotFound)
+		return
+	}
+	defer cancel()
+
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+	ctx.Header("X-Accel-Buffering", "no")
+	var ticker = time.NewTicker(streamKeepAlive)
+	defer ticker.Stop()
+	ctx.Stream(func(w io.Writer) bool {
+		select {
+		case <-ctx.Request.Context().Done():
+			return false
+		case <-ticker.C:
+			_, _ = w.Write([]byte(": keepalive\n\n"))
+			return true
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			ctx.SSEvent(event.Event, event.Data)
+			return event.Event != dispatcher.CompletedEvent
+		}
+	})
+}
+
+// HandleTaskWebSocket push events of task as websocket json messages
+func (api *HttpApi) HandleTaskWebSocket(ctx *gin.Context) {
+	events, cancel, ok := api.subscribeTask(ctx.Param("id"))
+	if !ok {
+		respondError(ctx, http.StatusNotFound, dispatcher.ErrTaskNotFound)
+		return
+	}
+	defer cancel()
+
+	conn, err := api.upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	if err != nil {
+		log.Logger.Debugf("upgrade websocket failed. %s", err)
+		return
+	}
+	defer conn.Close()
+
+	// read loop detect client closing
+	var closed = make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	var ticker = time.NewTicker(streamKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+			if err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				_ = conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+			if err = conn.WriteJSON(event); err != nil {
+				log.Logger.Debugf("write websocket message failed. %s", err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"detect-server/log"
+	"fmt"
+	"github.com/spf13/viper"
+	"os"
+	"sync"
+	"time"
+)
+
+// TlsOptions listener serves tls if Cert is set, certificate is reloaded
+// when Cert or Key file changes, client certificate is verified by ClientCA
+type TlsOptions struct {
+	Cert     string
+	Key      string
+	ClientCA string
+	// MinVersion one of 1.0, 1.1, 1.2 and 1.3
+	MinVersion string
+	// ReloadInterval interval of checking changes of certificate files
+	ReloadInterval time.Duration
+}
+
+func NewTlsOptions() TlsOptions {
+	var options = TlsOptions{
+		Cert:           viper.GetString("api.http.tls.cert"),
+		Key:            viper.GetString("api.http.tls.key"),
+		ClientCA:       viper.GetString("api.http.tls.clientCA"),
+		MinVersion:     viper.GetString("api.http.tls.minVersion"),
+		ReloadInterval: time.Duration(viper.GetInt("api.http.tls.reload.interval")) * time.Millisecond,
+	}
+
+	if options.MinVersion == "" {
+		options.MinVersion = "1.2"
+	}
+	if options.ReloadInterval <= 0 {
+		options.ReloadInterval = 10 * time.Second
+	}
+	return options
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newTlsConfig create tls config of listener, certificate is served by
+// reloader
+func newTlsConfig(options TlsOptions, reloader *certReloader) (*tls.Config, error) {
+	minVersion, ok := tlsVersions[options.MinVersion]
+	if !ok {
+		return nil, fmt.Errorf("invalid tls min version %s", options.MinVersion)
+	}
+	var config = &tls.Config{
+		MinVersion:     minVersion,
+		GetCertificate: reloader.getCertificate,
+	}
+	if options.ClientCA != "" {
+		data, err := os.ReadFile(options.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("read tls client ca failed. %s", err)
+		}
+		var pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate in tls client ca")
+		}
+		config.ClientCAs = pool
+		// clients without certificate can authenticate by other methods
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+type fileStat struct {
+	modTime time.Time
+	size    int64
+}
+
+// certReloader load certificate again when its files change, certificate
+// in use is kept if new files are invalid
+type certReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+	lock     sync.RWMutex
+	cert     *tls.Certificate
+	stats    [2]fileStat
+	stop     chan struct{}
+}
+
+func newCertReloader(certFile string, keyFile string, interval time.Duration) (*certReloader, error) {
+	var reloader = &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: interval,
+		stop:     make(chan struct{}),
+	}
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+func (reloader *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.lock.RLock()
+	defer reloader.lock.RUnlock()
+	return reloader.cert, nil
+}
+
+// load read certificate and key, stats are taken before reading, so files
+// changed while reading are loaded again next time
+func (reloader *certReloader) load() error {
+	stats, err := reloader.stat()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return fmt.Errorf("load tls certificate failed. %s", err)
+	}
+	reloader.lock.Lock()
+	reloader.cert = &cert
+	reloader.stats = stats
+	reloader.lock.Unlock()
+	return nil
+}
+
+func (reloader *certReloader) stat() ([2]fileStat, error) {
+	var stats [2]fileStat
+	for i, file := range []string{reloader.certFile, reloader.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return stats, fmt.Errorf("stat tls certificate failed. %s", err)
+		}
+		stats[i] = fileStat{modTime: info.ModTime(), size: info.Size()}
+	}
+	return stats, nil
+}
+
+// watch check files every interval until stopped
+func (reloader *certReloader) watch() {
+	var ticker = time.NewTicker(reloader.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-reloader.stop:
+			return
+		case <-ticker.C:
+			stats, err := reloader.stat()
+			reloader.lock.RLock()
+			var changed = err == nil && stats != reloader.stats
+			reloader.lock.RUnlock()
+			if !changed {
+				continue
+			}
+			if err = reloader.load(); err != nil {
+				log.Logger.Errorf("reload tls certificate failed, keep using current one. %s", err)
+				continue
+			}
+			log.Logger.Infof("tls certificate %s is reloaded", reloader.certFile)
+		}
+	}
+}
+
+func (reloader *certReloader) close() {
+	close(reloader.stop)
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert write self signed certificate of cn and its key
+func writeCert(t *testing.T, certFile string, keyFile string, cn string) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var template = &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate failed. %s", err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("write certificate failed. %s", err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatalf("write key failed. %s", err)
+	}
+}
+
+func TestCertReloader_Watch(t *testing.T) {
+	var dir = t.TempDir()
+	var certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, "first")
+
+	reloader, err := newCertReloader(certFile, keyFile, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("newCertReloader() error = %v", err)
+	}
+	defer reloader.close()
+	go reloader.watch()
+	var commonName = func() string {
+		cert, _ := reloader.getCertificate(nil)
+		leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+		return leaf.Subject.CommonName
+	}
+	if cn := commonName(); cn != "first" {
+		t.Fatalf("certificate = %s, want first", cn)
+	}
+
+	// invalid files keep current certificate
+	if err = os.WriteFile(keyFile, []byte("broken"), 0600); err != nil {
+		t.Fatalf("write key failed. %s", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if cn := commonName(); cn != "first" {
+		t.Errorf("certificate after broken key = %s, want first", cn)
+	}
+
+	writeCert(t, certFile, keyFile, "second")
+	for deadline := time.Now().Add(time.Second); commonName() != "second"; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("certificate is not reloaded")
+		}
+	}
+}
+
+func TestNewTlsConfig_MinVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		valid   bool
+	}{
+		{version: "1.2", valid: true},
+		{version: "1.3", valid: true},
+		{version: "1.4", valid: false},
+	}
+	for _, tt := range tests {
+		if _, err := newTlsConfig(TlsOptions{MinVersion: tt.version}, &certReloader{}); (err == nil) != tt.valid {
+			t.Errorf("newTlsConfig(%s) error = %v, valid %v", tt.version, err, tt.valid)
+		}
+	}
+}
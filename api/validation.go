@@ -0,0 +1,170 @@
+package api
+
+import (
+	"detect-server/dispatcher"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"io"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+func init() {
+	// field errors are reported by json names
+	if validate, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+			var name, _, _ = strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				return ""
+			}
+			if name == "" {
+				return field.Name
+			}
+			return name
+		})
+		_ = validate.RegisterValidation("httpurl", validateHttpUrl)
+	}
+}
+
+// validateHttpUrl accept http or https url, url without scheme like
+// host[:port][/path] is accepted as http detector defaults it to http
+func validateHttpUrl(field validator.FieldLevel) bool {
+	var text = field.Field().String()
+	if !strings.Contains(text, "://") {
+		text = "http://" + text
+	}
+	u, err := url.Parse(text)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Hostname() != ""
+}
+
+// FieldError invalid field of request, Field is json path like
+// icmp.targets[0], Rule is the violated rule
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// ValidationError request body is malformed or has invalid fields
+type ValidationError struct {
+	Reason string       `json:"reason"`
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Fields) == 0 {
+		return e.Reason
+	}
+	var messages = make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		messages = append(messages, field.Field+" "+field.Message)
+	}
+	return e.Reason + ". " + strings.Join(messages, ", ")
+}
+
+// parsePriority unknown priority is reported as field error
+func parsePriority(name string) (dispatcher.Priority, error) {
+	priority, err := dispatcher.ParsePriority(name)
+	if err != nil {
+		var param = "interactive normal bulk"
+		return "", &ValidationError{Reason: "invalid fields", Fields: []FieldError{{
+			Field:   "priority",
+			Rule:    "oneof",
+			Param:   param,
+			Message: ruleMessage("oneof", param),
+		}}}
+	}
+	return priority, nil
+}
+
+// decodePayload decode json strictly, unknown fields are rejected, then
+// validate fields by binding tags
+func decodePayload(reader io.Reader, payload any) error {
+	var decoder = json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(payload); err != nil {
+		return decodeError(err)
+	}
+	if decoder.More() {
+		return &ValidationError{Reason: "invalid json, body contains more than one value"}
+	}
+	return validatePayload(payload)
+}
+
+// validatePayload validate fields of payload by binding tags
+func validatePayload(payload any) error {
+	var err = binding.Validator.ValidateStruct(payload)
+	if err == nil {
+		return nil
+	}
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return &ValidationError{Reason: err.Error()}
+	}
+	var validationErr = &ValidationError{Reason: "invalid fields"}
+	for _, fieldErr := range errs {
+		// namespace starts with name of payload struct
+		var _, field, _ = strings.Cut(fieldErr.Namespace(), ".")
+		validationErr.Fields = append(validationErr.Fields, FieldError{
+			Field:   field,
+			Rule:    fieldErr.Tag(),
+			Param:   fieldErr.Param(),
+			Message: ruleMessage(fieldErr.Tag(), fieldErr.Param()),
+		})
+	}
+	return validationErr
+}
+
+func ruleMessage(rule string, param string) string {
+	switch rule {
+	case "required":
+		return "is required"
+	case "gte", "min":
+		return "must be at least " + param
+	case "lte", "max":
+		return "must be at most " + param
+	case "oneof":
+		return "must be one of " + strings.Join(strings.Fields(param), ", ")
+	case "hexadecimal":
+		return "must be hex encoded"
+	case "url":
+		return "must be url"
+	case "httpurl":
+		return "must be http url"
+	default:
+		return "does not match rule " + rule
+	}
+}
+
+// decodeError convert json error to ValidationError, field of type error
+// and unknown field are reported as field error
+func decodeError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return &ValidationError{Reason: "invalid fields", Fields: []FieldError{{
+			Field:   typeErr.Field,
+			Rule:    "type",
+			Param:   typeErr.Type.String(),
+			Message: fmt.Sprintf("must be %s, not %s", typeErr.Type, typeErr.Value),
+		}}}
+	}
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return &ValidationError{Reason: "invalid fields", Fields: []FieldError{{
+			Field:   strings.Trim(field, `"`),
+			Rule:    "unknown",
+			Message: "is not allowed",
+		}}}
+	}
+	if errors.Is(err, io.EOF) {
+		return &ValidationError{Reason: "invalid json, body is empty"}
+	}
+	return &ValidationError{Reason: "invalid json. " + err.Error()}
+}
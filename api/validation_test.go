@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodePayload(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantReason string
+		wantFields []string
+	}{
+		{name: "valid", body: `{"tcp":{"ports":[80],"targets":["10.0.0.1"]}}`},
+		{name: "empty body", body: ``, wantReason: "invalid json, body is empty"},
+		{name: "malformed", body: `{"icmp":`, wantReason: "invalid json. unexpected EOF"},
+		{name: "unknown field", body: `{"icmp":{"target":["10.0.0.1"]}}`, wantFields: []string{"target"}},
+		{name: "type", body: `{"icmp":{"count":"3","targets":["10.0.0.1"]}}`, wantFields: []string{"icmp.count"}},
+		{name: "nested fields", body: `{"icmp":{"count":101,"targets":[""]},"tcp":{"ports":[0],"targets":["10.0.0.1"]}}`,
+			wantFields: []string{"icmp.count", "icmp.targets[0]", "tcp.ports[0]"}},
+		{name: "http url", body: `{"http":{"targets":["https://example.com/health","example.com:8080/health","example.com"]}}`},
+		{name: "invalid http url", body: `{"http":{"targets":["ftp://example.com","http://","example.com:port"]}}`,
+			wantFields: []string{"http.targets[0]", "http.targets[1]", "http.targets[2]"}},
+		{name: "more values", body: `{} {}`, wantReason: "invalid json, body contains more than one value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var payload DetectPayload
+			var err = decodePayload(strings.NewReader(tt.body), &payload)
+			if tt.wantReason == "" && tt.wantFields == nil {
+				if err != nil {
+					t.Errorf("decodePayload() error = %v", err)
+				}
+				return
+			}
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("decodePayload() error = %v, want ValidationError", err)
+			}
+			if tt.wantReason != "" && validationErr.Reason != tt.wantReason {
+				t.Errorf("decodePayload() reason = %s, want %s", validationErr.Reason, tt.wantReason)
+			}
+			var fields []string
+			for _, field := range validationErr.Fields {
+				fields = append(fields, field.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("decodePayload() fields = %v, want %v", fields, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestHttpApi_OpenApi(t *testing.T) {
+	var api = NewHttpApi(HttpApiOptions{})
+	authenticators, _ := NewAuthenticators(AuthOptions{Keys: []ApiKey{{Key: "key", Name: "reader", Roles: []string{RoleRead}}}}, "")
+	api.AddAuthenticator(authenticators[0])
+
+	var recorder = httptest.NewRecorder()
+	api.srv.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET /openapi.json status = %d, want %d", recorder.Code, http.StatusOK)
+	}
+	var spec struct {
+		Paths map[string]map[string]any `json:"paths"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("openapi.json is invalid. %s", err)
+	}
+	// every route is documented
+	for _, route := range api.srv.Routes() {
+		var path = strings.ReplaceAll(route.Path, ":id", "{id}")
+		if _, ok := spec.Paths[path][strings.ToLower(route.Method)]; !ok {
+			t.Errorf("route %s %s is not documented", route.Method, route.Path)
+		}
+	}
+}
@@ -1,17 +1,23 @@
 package cmd
 
 import (
+	"context"
 	"detect-server/api"
 	"detect-server/connector"
 	"detect-server/detector"
 	dispatcher "detect-server/dispatcher"
 	"detect-server/log"
+	"detect-server/scheduler"
 	"detect-server/sender"
-	"github.com/go-ping/ping"
+	"detect-server/storage"
+	"fmt"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	_ "gopkg.in/yaml.v3"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 // startCmd represents the start command
@@ -41,34 +47,79 @@ func init() {
 
 func startDetectServer() {
 	var (
-		icmpConnectorOptions = connector.Options{
-			MaxBufferSize: viper.GetInt("connector.icmp.buffer.size"),
+		taskConnectorOptions = connector.Options{
+			MaxBufferSize: viper.GetInt("connector.task.buffer.size"),
 		}
 		msgConnectorOptions = connector.Options{
 			MaxBufferSize: viper.GetInt("sender.buffer.size"),
 		}
 		icmpDetectorOptions = detector.NewIcmpDetectorOptions()
+		tcpDetectorOptions  = detector.NewTcpDetectorOptions()
+		udpDetectorOptions  = detector.NewUdpDetectorOptions()
+		httpDetectorOptions = detector.NewHttpDetectorOptions()
 		dispatcherOptions   = dispatcher.NewOptions()
+		trackerOptions      = dispatcher.NewTrackerOptions()
+		brokerOptions       = dispatcher.NewBrokerOptions()
+		filterOptions       = dispatcher.NewFilterOptions()
+		limiterOptions      = dispatcher.NewLimiterOptions()
 		httpApiOptions      = api.NewHttpApiOptions()
+		grpcApiOptions      = api.NewGrpcApiOptions()
 		kafkaSenderOptions  = sender.NewKafkaSenderOptions()
+		storeOptions        = storage.NewOptions()
+		shutdownOptions     = newShutdownOptions()
 	)
 
+	// open store before components using it
+	store, err := storage.NewStore(storeOptions)
+	if err != nil {
+		log.Logger.Errorf("open store failed. %s", err)
+		os.Exit(1)
+	}
+
+	filter, err := dispatcher.NewFilter(filterOptions)
+	if err != nil {
+		log.Logger.Errorf("create target filter failed. %s", err)
+		os.Exit(1)
+	}
+
+	authenticators, err := api.NewAuthenticators(httpApiOptions.Auth, httpApiOptions.Tls.ClientCA)
+	if err != nil {
+		log.Logger.Errorf("create api authenticators failed. %s", err)
+		os.Exit(1)
+	}
+
 	var (
-		icmpConnector = connector.NewChanConnector[dispatcher.Task[detector.IcmpOptions]](icmpConnectorOptions)
+		taskConnector = connector.NewChanConnector[dispatcher.Task](taskConnectorOptions)
 		msgConnector  = connector.NewChanConnector[any](msgConnectorOptions)
-		dispatch      = dispatcher.NewDispatcher[detector.IcmpOptions, *ping.Statistics, dispatcher.DefaultMessage](dispatcherOptions)
+		dispatch      = dispatcher.NewDispatcher(dispatcherOptions)
+		tracker       = dispatcher.NewTracker(trackerOptions)
+		broker        = dispatcher.NewBroker(brokerOptions)
+		jobScheduler  = scheduler.NewScheduler()
 		icmpDetector  = detector.NewIcmpDetector(icmpDetectorOptions)
+		tcpDetector   = detector.NewTcpDetector(tcpDetectorOptions)
+		udpDetector   = detector.NewUdpDetector(udpDetectorOptions)
+		httpDetector  = detector.NewHttpDetector(httpDetectorOptions)
 		httpApi       = api.NewHttpApi(httpApiOptions)
 		kafkaSender   = sender.NewKafkaSender(kafkaSenderOptions)
-		processor     = dispatcher.NewDefaultProcessor[detector.IcmpOptions, *ping.Statistics, dispatcher.DefaultMessage]()
 	)
 
 	// start detector
-	var err = icmpDetector.Start()
-	if err != nil {
+	if err = icmpDetector.Start(); err != nil {
 		log.Logger.Errorf("start icmp detector failed. %s", err)
 		os.Exit(1)
 	}
+	if err = tcpDetector.Start(); err != nil {
+		log.Logger.Errorf("start tcp detector failed. %s", err)
+		os.Exit(1)
+	}
+	if err = udpDetector.Start(); err != nil {
+		log.Logger.Errorf("start udp detector failed. %s", err)
+		os.Exit(1)
+	}
+	if err = httpDetector.Start(); err != nil {
+		log.Logger.Errorf("start http detector failed. %s", err)
+		os.Exit(1)
+	}
 
 	// start sender
 	kafkaSender.AddReceiver(msgConnector)
@@ -78,19 +129,179 @@ func startDetectServer() {
 	}
 
 	// start dispatcher
-	dispatch.AddReceiver(icmpConnector)
-	dispatch.AddDetector(icmpDetector)
+	dispatch.AddReceiver(taskConnector)
+	dispatch.AddRoute(dispatcher.NewRoute(detector.ICMPDetect, icmpDetector,
+		dispatcher.NewDefaultProcessor[detector.IcmpOptions, *detector.IcmpStatistics, dispatcher.DefaultMessage]()))
+	dispatch.AddRoute(dispatcher.NewRoute(detector.TCPDetect, tcpDetector,
+		dispatcher.NewDefaultProcessor[detector.TcpOptions, *detector.TcpStatistics, dispatcher.DefaultMessage]()))
+	dispatch.AddRoute(dispatcher.NewRoute(detector.UDPDetect, udpDetector,
+		dispatcher.NewDefaultProcessor[detector.UdpOptions, *detector.UdpStatistics, dispatcher.DefaultMessage]()))
+	dispatch.AddRoute(dispatcher.NewRoute(detector.HTTPDetect, httpDetector,
+		dispatcher.NewDefaultProcessor[detector.HttpOptions, *detector.HttpStatistics, dispatcher.DefaultMessage]()))
+	tracker.AddStore(store)
+	if err = tracker.Load(); err != nil {
+		log.Logger.Errorf("load tasks failed. %s", err)
+		os.Exit(1)
+	}
 	dispatch.AddPublisher(msgConnector)
-	dispatch.AddProcessor(processor)
+	dispatch.AddTracker(tracker)
+	dispatch.AddBroker(broker)
+	dispatch.AddFilter(filter)
+	dispatch.AddLimiter(dispatcher.NewLimiter(limiterOptions))
 
 	if err := dispatch.Start(); err != nil {
 		log.Logger.Errorf("start dispatcher failed. %s", err)
 		os.Exit(1)
 	}
 
+	// start scheduler
+	jobScheduler.AddPublisher(taskConnector)
+	jobScheduler.AddTracker(tracker)
+	jobScheduler.AddBuilder(httpApi.ConvertPayload)
+	jobScheduler.AddStore(store)
+	if err := jobScheduler.Start(); err != nil {
+		log.Logger.Errorf("start scheduler failed. %s", err)
+		os.Exit(1)
+	}
+
 	// start api
-	httpApi.AddIcmpPublisher(icmpConnector)
-	if err := httpApi.Start(); err != nil {
-		log.Logger.Errorf("start http api failed. %s", err)
+	httpApi.AddTaskPublisher(taskConnector)
+	httpApi.AddTracker(tracker)
+	httpApi.AddDispatcher(dispatch)
+	httpApi.AddBroker(broker)
+	httpApi.AddScheduler(jobScheduler)
+	for _, authenticator := range authenticators {
+		httpApi.AddAuthenticator(authenticator)
+	}
+	var (
+		apiErrs = make(chan error, 2)
+		grpcApi *api.GrpcApi
+	)
+	if grpcApiOptions.Listen != "" {
+		grpcApi = api.NewGrpcApi(grpcApiOptions, httpApi)
+		go func() {
+			if err := grpcApi.Start(); err != nil {
+				apiErrs <- fmt.Errorf("start grpc api failed. %w", err)
+			}
+		}()
+	}
+	go func() {
+		if err := httpApi.Start(); err != nil {
+			apiErrs <- fmt.Errorf("start http api failed. %w", err)
+		}
+	}()
+
+	var (
+		signals  = make(chan os.Signal, 1)
+		exitCode int
+	)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	select {
+	case sig := <-signals:
+		log.Logger.Infof("receive signal %s, shutting down", sig)
+	case err := <-apiErrs:
+		log.Logger.Errorf("%s", err)
+		exitCode = 1
+	}
+	go func() {
+		sig := <-signals
+		log.Logger.Warnf("receive signal %s again, exit without shutdown", sig)
+		os.Exit(1)
+	}()
+
+	// stop accepting requests, then wait tasks received finished and
+	// detectors idle, then flush results to kafka
+	var drainCtx, cancel = context.WithTimeout(context.Background(), shutdownOptions.DrainTimeout)
+	defer cancel()
+	stopWithin(drainCtx, "http api", httpApi.Stop)
+	if grpcApi != nil {
+		stopWithin(drainCtx, "grpc api", grpcApi.Stop)
+	}
+	stopWithin(drainCtx, "scheduler", ignoreContext(jobScheduler.Stop))
+	stopWithin(drainCtx, "running tasks", dispatch.Drain)
+
+	var stops = []struct {
+		name string
+		stop func() error
+	}{
+		{"icmp detector", icmpDetector.Stop},
+		{"tcp detector", tcpDetector.Stop},
+		{"udp detector", udpDetector.Stop},
+		{"http detector", httpDetector.Stop},
+		{"dispatcher", dispatch.Stop},
+		{"kafka sender", kafkaSender.Stop},
+		{"store", store.Close},
+	}
+	for _, component := range stops {
+		var ctx, cancel = context.WithTimeout(context.Background(), shutdownOptions.StopTimeout)
+		stopWithin(ctx, component.name, ignoreContext(component.stop))
+		cancel()
+	}
+	log.Logger.Infof("detect server is shut down")
+	os.Exit(exitCode)
+}
+
+// shutdownOptions DrainTimeout bounds stopping api and waiting tasks
+// finished, tasks left are cancelled then. StopTimeout bounds stopping
+// every component after draining, e.g. flushing kafka sender
+type shutdownOptions struct {
+	DrainTimeout time.Duration
+	StopTimeout  time.Duration
+}
+
+func newShutdownOptions() shutdownOptions {
+	var options = shutdownOptions{
+		DrainTimeout: time.Duration(viper.GetInt("shutdown.drain.timeout")) * time.Millisecond,
+		StopTimeout:  time.Duration(viper.GetInt("shutdown.stop.timeout")) * time.Millisecond,
+	}
+
+	if options.DrainTimeout <= 0 {
+		options.DrainTimeout = 30 * time.Second
+	}
+	if options.StopTimeout <= 0 {
+		options.StopTimeout = 10 * time.Second
+	}
+	return options
+}
+
+// stopWithin run stop until it returns or ctx is done, stop still running
+// is abandoned since server is exiting. stop honouring ctx has a second to
+// return after ctx is done
+func stopWithin(ctx context.Context, name string, stop func(context.Context) error) {
+	var (
+		start   = time.Now()
+		errs    = make(chan error, 1)
+		timeout <-chan time.Time
+	)
+	log.Logger.Infof("stopping %s", name)
+	go func() {
+		errs <- stop(ctx)
+	}()
+	select {
+	case err := <-errs:
+		reportStop(name, start, err)
+		return
+	case <-ctx.Done():
+		timeout = time.After(time.Second)
+	}
+	select {
+	case err := <-errs:
+		reportStop(name, start, err)
+	case <-timeout:
+		log.Logger.Warnf("stop %s timeout after %s", name, time.Since(start).Round(time.Millisecond))
+	}
+}
+
+func reportStop(name string, start time.Time, err error) {
+	if err != nil {
+		log.Logger.Warnf("stop %s failed. %s", name, err)
+		return
+	}
+	log.Logger.Infof("%s stopped in %s", name, time.Since(start).Round(time.Millisecond))
+}
+
+func ignoreContext(stop func() error) func(context.Context) error {
+	return func(context.Context) error {
+		return stop()
 	}
 }
@@ -1,7 +1,8 @@
 package detector
 
 import (
-	"github.com/go-ping/ping"
+	"context"
+	"net"
 )
 
 // Detector support sync and async method to detect target
@@ -24,6 +25,7 @@ const (
 	ICMPDetect DetectType = "icmp"
 	TCPDetect  DetectType = "tcp"
 	UDPDetect  DetectType = "udp"
+	HTTPDetect DetectType = "http"
 )
 
 type DetectStatus = string
@@ -32,14 +34,156 @@ type DetectInput interface {
 	IcmpOptions | TcpOptions | UdpOptions | HttpOptions
 }
 
+// DetectOutput result of every protocol supply a protocol independent summary,
+// the result itself is the protocol specific detail
 type DetectOutput interface {
-	*ping.Statistics
+	Summary() Summary
 }
 
+// Target is implemented by DetectTarget of all protocols, so targets of
+// different protocols can be carried by one task
+type Target interface {
+	DetectType() DetectType
+	Address() string
+	TaskId() string
+	// WithTask return copy of target belongs to task
+	WithTask(id string) Target
+	// Context is done when task of target is cancelled, detector skips
+	// target or aborts detecting it
+	Context() context.Context
+	// WithContext return copy of target bound to ctx
+	WithContext(ctx context.Context) Target
+	// WithResolved return copy of target probing ips instead of resolving
+	// its host again, so address checked by filter is the probed address
+	WithResolved(ips []net.IP) Target
+	// ResolvedIP ip of host the target is probed at, nil if host is not
+	// resolved before detecting
+	ResolvedIP() net.IP
+	// WithDialCheck return copy of target whose detector checks every ip
+	// it dials by check, e.g. hosts of http redirects, dial fails if check
+	// returns error
+	WithDialCheck(check func(ip net.IP) error) Target
+	// Probes number of probes target sends, e.g. icmp echo or tcp connect
+	Probes() int
+}
+
+// DetectTarget Task is id of the task target belongs to
 type DetectTarget[T DetectInput] struct {
 	Type    DetectType
 	Target  string
+	Task    string
 	Options DetectOptions[T]
+	ctx     context.Context
+	// resolved ips of host, host is resolved by detector if it is empty
+	resolved []net.IP
+	// dialCheck check ip dialed by detector, nil means all ips are allowed
+	dialCheck func(ip net.IP) error
+}
+
+func (target DetectTarget[T]) DetectType() DetectType {
+	return target.Type
+}
+
+func (target DetectTarget[T]) Address() string {
+	return target.Target
+}
+
+func (target DetectTarget[T]) TaskId() string {
+	return target.Task
+}
+
+// Probes is count multiplied by ports of tcp and udp target, count not
+// set is taken as 1 since default count is known by detector only
+func (target DetectTarget[T]) Probes() int {
+	var count = target.Options.Count
+	if count <= 0 {
+		count = 1
+	}
+	switch options := any(target.Options.Options).(type) {
+	case TcpOptions:
+		count *= len(options.Ports)
+	case UdpOptions:
+		count *= len(options.DetectPorts())
+	}
+	if count <= 0 {
+		count = 1
+	}
+	return count
+}
+
+func (target DetectTarget[T]) WithTask(id string) Target {
+	target.Task = id
+	return target
+}
+
+// Context of target not bound to task is never done
+func (target DetectTarget[T]) Context() context.Context {
+	if target.ctx == nil {
+		return context.Background()
+	}
+	return target.ctx
+}
+
+func (target DetectTarget[T]) WithContext(ctx context.Context) Target {
+	target.ctx = ctx
+	return target
+}
+
+func (target DetectTarget[T]) WithResolved(ips []net.IP) Target {
+	target.resolved = ips
+	return target
+}
+
+func (target DetectTarget[T]) WithDialCheck(check func(ip net.IP) error) Target {
+	target.dialCheck = check
+	return target
+}
+
+// ResolvedIP return ip to probe of resolved ips, ipv4 is preferred same
+// as resolver of net package
+func (target DetectTarget[T]) ResolvedIP() net.IP {
+	for _, ip := range target.resolved {
+		if ip.To4() != nil {
+			return ip
+		}
+	}
+	if len(target.resolved) > 0 {
+		return target.resolved[0]
+	}
+	return nil
+}
+
+// probeHost return resolved ip of target if any, or target itself
+func (target DetectTarget[T]) probeHost() string {
+	if ip := target.ResolvedIP(); ip != nil {
+		return ip.String()
+	}
+	return target.Target
+}
+
+// detectContext is done when detector is stopped or task of target is
+// cancelled, cancel must be called when target is detected
+func detectContext[T DetectInput](parent context.Context, target DetectTarget[T]) (context.Context, context.CancelFunc) {
+	if parent == nil {
+		parent = context.Background()
+	}
+	var ctx, cancel = context.WithCancel(parent)
+	var taskCtx = target.Context()
+	if taskCtx.Done() == nil {
+		return ctx, cancel
+	}
+	if taskCtx.Err() != nil {
+		cancel()
+		return ctx, cancel
+	}
+	go func() {
+		select {
+		case <-taskCtx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
 }
 
 type DetectOptions[T DetectInput] struct {
@@ -1,4 +1,449 @@
 package detector
 
+import (
+	"context"
+	"crypto/tls"
+	"detect-server/log"
+	"fmt"
+	"github.com/spf13/viper"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	neturl "net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// maxHttpBodySize max size of response body read for assertion
+const maxHttpBodySize = 1 << 20
+
+// HttpOptions ExpectedStatus is empty means any status less than 400 is
+// accepted. BodyRegex and BodyContains assert response body. redirects are
+// followed at most MaxRedirects times when FollowRedirects is true
 type HttpOptions struct {
+	Method          string
+	Headers         map[string]string
+	Body            string
+	ExpectedStatus  []int
+	BodyRegex       string
+	BodyContains    string
+	FollowRedirects bool
+	MaxRedirects    int
+	Insecure        bool
+}
+
+// Validate check options can be used to build request
+func (options HttpOptions) Validate() error {
+	if options.BodyRegex != "" {
+		if _, err := regexp.Compile(options.BodyRegex); err != nil {
+			return fmt.Errorf("invalid body regex. %s", err)
+		}
+	}
+	for _, status := range options.ExpectedStatus {
+		if status < 100 || status > 599 {
+			return fmt.Errorf("invalid expected status %d", status)
+		}
+	}
+	if options.MaxRedirects < 0 {
+		return fmt.Errorf("invalid max redirects %d", options.MaxRedirects)
+	}
+	return nil
+}
+
+// HttpTiming time used by every phase of request, DNS is zero when host
+// is resolved by filter before detecting
+type HttpTiming struct {
+	DNS          time.Duration
+	Connect      time.Duration
+	TLSHandshake time.Duration
+	TTFB         time.Duration
+	Total        time.Duration
+}
+
+// HttpAttemptStatistics result of single request
+type HttpAttemptStatistics struct {
+	StatusCode int
+	BodySize   int
+	Redirects  int
+	Success    bool
+	Timing     HttpTiming
+	ErrorClass ErrorClass
+	Error      string
+}
+
+// HttpStatistics result of all requests to target
+type HttpStatistics struct {
+	URL       string
+	Successes int
+	Attempts  []*HttpAttemptStatistics
+}
+
+// Summary latency is the total time of every responded request
+func (statistics *HttpStatistics) Summary() Summary {
+	if statistics == nil {
+		return Summary{}
+	}
+	var latencies = make([]time.Duration, 0)
+	var errorClass = ErrorNone
+	for _, attempt := range statistics.Attempts {
+		if attempt.StatusCode > 0 {
+			latencies = append(latencies, attempt.Timing.Total)
+		}
+		if !attempt.Success && errorClass == ErrorNone {
+			errorClass = attempt.ErrorClass
+		}
+	}
+	var summary = NewSummary(len(statistics.Attempts), statistics.Successes, latencies)
+	summary.Success = len(statistics.Attempts) > 0 && statistics.Successes == len(statistics.Attempts)
+	summary.ErrorClass = errorClass
+	return summary
+}
+
+type HttpDetectorOptions struct {
+	DefaultTimeout      int
+	DefaultCount        int
+	MaxRunnerCount      int
+	MaxDetectBufferSize int
+	MaxResultQueueSize  int
+}
+
+func NewHttpDetectorOptions() HttpDetectorOptions {
+	var options = HttpDetectorOptions{
+		DefaultTimeout:      viper.GetInt("detector.http.detect.timeout"),
+		DefaultCount:        viper.GetInt("detector.http.detect.count"),
+		MaxRunnerCount:      viper.GetInt("detector.http.runner.count"),
+		MaxDetectBufferSize: viper.GetInt("detector.http.detect.buffer.size"),
+		MaxResultQueueSize:  viper.GetInt("detector.http.detect.result.queue.size"),
+	}
+
+	if options.DefaultTimeout <= 0 {
+		options.DefaultTimeout = 5000
+	}
+	if options.DefaultCount <= 0 {
+		options.DefaultCount = 1
+	}
+	if options.MaxDetectBufferSize <= 0 {
+		options.MaxDetectBufferSize = 1
+	}
+	if options.MaxRunnerCount <= 0 {
+		options.MaxRunnerCount = 10
+	}
+
+	return options
+}
+
+// HttpDetector detect web endpoint use http or https request
+type HttpDetector struct {
+	options          HttpDetectorOptions
+	detectBuffer     chan DetectTarget[HttpOptions]
+	resultQueue      chan DetectResult[HttpOptions, *HttpStatistics]
+	parentCtx        context.Context
+	parentCancelFunc context.CancelFunc
+}
+
+func NewHttpDetector(options HttpDetectorOptions) Detector[HttpOptions, *HttpStatistics] {
+	var detector = &HttpDetector{
+		options:      options,
+		detectBuffer: make(chan DetectTarget[HttpOptions], options.MaxDetectBufferSize),
+		resultQueue:  make(chan DetectResult[HttpOptions, *HttpStatistics], options.MaxResultQueueSize),
+	}
+
+	return detector
+}
+
+func (detector *HttpDetector) Start() error {
+	if detector.resultQueue == nil {
+		return fmt.Errorf("result queue can not be nil")
+	}
+
+	if detector.detectBuffer == nil {
+		return fmt.Errorf("detect queue can not be nil")
+	}
+	detector.parentCtx, detector.parentCancelFunc = context.WithCancel(context.Background())
+	for i := 1; i <= detector.options.MaxRunnerCount; i++ {
+		go func(idx int) {
+			var runnerName = fmt.Sprintf("runner%d", idx)
+			log.Logger.Debugf("start http detector runner %s", runnerName)
+			err := detector.startRunner(runnerName)
+			if err != nil {
+				log.Logger.Errorf("%s", err)
+			}
+		}(i)
+	}
+	return nil
+}
+
+func (detector *HttpDetector) startRunner(name string) error {
+	var ctx, cancelFunc = context.WithCancel(detector.parentCtx)
+	defer cancelFunc()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Logger.Infof("stopping runner %s", name)
+			return nil
+		case detect := <-detector.detectBuffer:
+			var result = detector.Detect(detect)
+			detector.resultQueue <- result
+		}
+	}
+}
+
+func (detector *HttpDetector) Stop() error {
+	for length := len(detector.detectBuffer); length > 0; length = len(detector.detectBuffer) {
+		time.Sleep(time.Second)
+	}
+	log.Logger.Infof("current length of target queue is 0, stopping detector")
+	detector.parentCancelFunc()
+	return nil
+}
+
+// Detect send Count requests to target one by one, target without
+// scheme is treated as http url
+func (detector *HttpDetector) Detect(target DetectTarget[HttpOptions]) DetectResult[HttpOptions, *HttpStatistics] {
+	var result = DetectResult[HttpOptions, *HttpStatistics]{
+		Target: target,
+	}
+	var ctx, cancel = detectContext(detector.parentCtx, target)
+	defer cancel()
+	if err := ctx.Err(); err != nil {
+		result.Error = err
+		return result
+	}
+	var options = target.Options.Options
+	if err := options.Validate(); err != nil {
+		result.Error = err
+		return result
+	}
+	if target.Options.Count <= 0 {
+		target.Options.Count = detector.options.DefaultCount
+	}
+	if target.Options.Timeout <= 0 {
+		target.Options.Timeout = detector.options.DefaultTimeout
+	}
+	var url = target.Target
+	if !strings.Contains(url, "://") {
+		url = "http://" + url
+	}
+
+	var host string
+	if u, err := neturl.Parse(url); err == nil {
+		host = u.Hostname()
+	}
+	var client = newHttpClient(options, time.Duration(target.Options.Timeout)*time.Millisecond, host,
+		target.ResolvedIP(), target.dialCheck)
+	defer client.CloseIdleConnections()
+
+	var statistics = &HttpStatistics{
+		URL:      url,
+		Attempts: make([]*HttpAttemptStatistics, 0, target.Options.Count),
+	}
+	for i := 0; i < target.Options.Count && ctx.Err() == nil; i++ {
+		var attempt = doHttpRequest(ctx, client, url, options)
+		if attempt.Success {
+			statistics.Successes++
+		}
+		statistics.Attempts = append(statistics.Attempts, attempt)
+	}
+
+	result.Target = target
+	result.Result = statistics
+	return result
+}
+
+// newHttpClient create client without connection reuse, so every request
+// contains dns, connect and tls handshake phases, host is dialed at ip if
+// it is not nil. every ip dialed, including hosts of redirects, is checked
+// by check if it is not nil, proxy is not used in that case since the
+// proxy would dial addresses not checked
+func newHttpClient(options HttpOptions, timeout time.Duration, host string, ip net.IP,
+	check func(ip net.IP) error) *http.Client {
+	var transport = &http.Transport{
+		Proxy:             http.ProxyFromEnvironment,
+		DisableKeepAlives: true,
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: options.Insecure},
+	}
+	var dialer = &net.Dialer{}
+	if check != nil {
+		transport.Proxy = nil
+		// control runs with the resolved address right before connecting
+		dialer.Control = func(network string, address string, _ syscall.RawConn) error {
+			addrHost, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			var dialIp = net.ParseIP(addrHost)
+			if dialIp == nil {
+				return fmt.Errorf("%w, dial address %s is not ip", ErrTargetFiltered, address)
+			}
+			return check(dialIp)
+		}
+	}
+	transport.DialContext = func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		if ip != nil {
+			if addrHost, port, err := net.SplitHostPort(addr); err == nil && addrHost == host {
+				addr = net.JoinHostPort(ip.String(), port)
+			}
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+	var maxRedirects = options.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = 10
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if !options.FollowRedirects {
+				return http.ErrUseLastResponse
+			}
+			if len(via) > maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			return nil
+		},
+	}
+}
+
+// httpTimingTrace record time of request phases, callbacks of trace may
+// run on goroutines of transport, even after request returns
+type httpTimingTrace struct {
+	lock         sync.Mutex
+	timing       HttpTiming
+	start        time.Time
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+}
+
+func (t *httpTimingTrace) record(fn func()) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	fn()
+}
+
+func (t *httpTimingTrace) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { t.record(func() { t.dnsStart = time.Now() }) },
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.record(func() { t.timing.DNS += time.Since(t.dnsStart) })
+		},
+		ConnectStart: func(string, string) { t.record(func() { t.connectStart = time.Now() }) },
+		ConnectDone: func(string, string, error) {
+			t.record(func() { t.timing.Connect += time.Since(t.connectStart) })
+		},
+		TLSHandshakeStart: func() { t.record(func() { t.tlsStart = time.Now() }) },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.record(func() { t.timing.TLSHandshake += time.Since(t.tlsStart) })
+		},
+		GotFirstResponseByte: func() {
+			t.record(func() { t.timing.TTFB = time.Since(t.start) })
+		},
+	}
+}
+
+// get copy timing recorded so far
+func (t *httpTimingTrace) get() HttpTiming {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.timing
+}
+
+func doHttpRequest(ctx context.Context, client *http.Client, url string, options HttpOptions) *HttpAttemptStatistics {
+	var attempt = &HttpAttemptStatistics{}
+	var method = options.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	var start = time.Now()
+	var timing = &httpTimingTrace{start: start}
+	request, err := http.NewRequestWithContext(ctx, method, url, strings.NewReader(options.Body))
+	if err != nil {
+		attempt.Error = err.Error()
+		attempt.ErrorClass = ErrorUnknown
+		return attempt
+	}
+	for key, value := range options.Headers {
+		if strings.EqualFold(key, "host") {
+			request.Host = value
+			continue
+		}
+		request.Header.Set(key, value)
+	}
+	request = request.WithContext(httptrace.WithClientTrace(request.Context(), timing.trace()))
+
+	response, err := client.Do(request)
+	attempt.Timing = timing.get()
+	if err != nil {
+		attempt.Timing.Total = time.Since(start)
+		attempt.Error = err.Error()
+		attempt.ErrorClass = ClassifyError(err)
+		return attempt
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxHttpBodySize))
+	attempt.Timing.Total = time.Since(start)
+	attempt.StatusCode = response.StatusCode
+	attempt.BodySize = len(body)
+	for req := response.Request; req != nil && req.Response != nil; req = req.Response.Request {
+		attempt.Redirects++
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+		attempt.ErrorClass = ClassifyError(err)
+		return attempt
+	}
+
+	if err = assertHttpResponse(response.StatusCode, body, options); err != nil {
+		attempt.Error = err.Error()
+		attempt.ErrorClass = ErrorAssertion
+		return attempt
+	}
+	attempt.Success = true
+	return attempt
+}
+
+// assertHttpResponse check status code and body match expectation
+func assertHttpResponse(status int, body []byte, options HttpOptions) error {
+	if len(options.ExpectedStatus) == 0 {
+		if status >= 400 {
+			return fmt.Errorf("unexpected status %d", status)
+		}
+	} else {
+		var matched = false
+		for _, expected := range options.ExpectedStatus {
+			if status == expected {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("unexpected status %d, expected %v", status, options.ExpectedStatus)
+		}
+	}
+	if options.BodyContains != "" && !strings.Contains(string(body), options.BodyContains) {
+		return fmt.Errorf("body does not contain %q", options.BodyContains)
+	}
+	if options.BodyRegex != "" {
+		var pattern = regexp.MustCompile(options.BodyRegex)
+		if !pattern.Match(body) {
+			return fmt.Errorf("body does not match %q", options.BodyRegex)
+		}
+	}
+	return nil
+}
+
+// Detects detect target async
+func (detector *HttpDetector) Detects() chan<- DetectTarget[HttpOptions] {
+	return detector.detectBuffer
+}
+
+func (detector *HttpDetector) Results() <-chan DetectResult[HttpOptions, *HttpStatistics] {
+	return detector.resultQueue
 }
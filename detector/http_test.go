@@ -0,0 +1,136 @@
+package detector
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHttpDetector_Detect(t *testing.T) {
+	var mux = http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("service is healthy"))
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ok", http.StatusFound)
+	})
+	mux.HandleFunc("/error", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	var server = httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		path        string
+		options     HttpOptions
+		wantSuccess bool
+		wantStatus  int
+		wantErr     bool
+	}{
+		{name: "ok", path: "/ok", wantSuccess: true, wantStatus: 200},
+		{name: "contains", path: "/ok", options: HttpOptions{BodyContains: "healthy"}, wantSuccess: true, wantStatus: 200},
+		{name: "not contains", path: "/ok", options: HttpOptions{BodyContains: "broken"}, wantSuccess: false, wantStatus: 200},
+		{name: "regex", path: "/ok", options: HttpOptions{BodyRegex: "^service .* healthy$"}, wantSuccess: true, wantStatus: 200},
+		{name: "no follow", path: "/redirect", options: HttpOptions{ExpectedStatus: []int{302}}, wantSuccess: true, wantStatus: 302},
+		{name: "follow", path: "/redirect", options: HttpOptions{FollowRedirects: true}, wantSuccess: true, wantStatus: 200},
+		{name: "server error", path: "/error", wantSuccess: false, wantStatus: 500},
+		{name: "invalid regex", path: "/ok", options: HttpOptions{BodyRegex: "("}, wantErr: true},
+	}
+	var detector = NewHttpDetector(HttpDetectorOptions{DefaultTimeout: 1000, DefaultCount: 1})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var target = NewDetectTarget(HTTPDetect, server.URL+tt.path, DetectOptions[HttpOptions]{Options: tt.options})
+			got := detector.Detect(target)
+			if (got.Error != nil) != tt.wantErr {
+				t.Errorf("Detect() error = %v, wantErr %v", got.Error, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			var attempt = got.Result.Attempts[0]
+			if attempt.Success != tt.wantSuccess {
+				t.Errorf("Detect() success = %v, want %v, error %s", attempt.Success, tt.wantSuccess, attempt.Error)
+			}
+			if attempt.StatusCode != tt.wantStatus {
+				t.Errorf("Detect() status = %v, want %v", attempt.StatusCode, tt.wantStatus)
+			}
+			if attempt.Timing.Total <= 0 || attempt.Timing.TTFB <= 0 {
+				t.Errorf("Detect() timing = %+v, want total and ttfb", attempt.Timing)
+			}
+		})
+	}
+}
+
+func TestHttpDetector_DetectResolved(t *testing.T) {
+	var hosts = make(chan string, 1)
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts <- r.Host
+	}))
+	defer server.Close()
+
+	// host is dialed at ip resolved by filter and kept in request
+	var port = server.Listener.Addr().(*net.TCPAddr).Port
+	var host = net.JoinHostPort("unknown.invalid", strconv.Itoa(port))
+	var detector = NewHttpDetector(HttpDetectorOptions{DefaultTimeout: 1000, DefaultCount: 1})
+	var target = NewDetectTarget(HTTPDetect, "http://"+host+"/", DetectOptions[HttpOptions]{}).
+		WithResolved([]net.IP{net.ParseIP("127.0.0.1")}).(DetectTarget[HttpOptions])
+	got := detector.Detect(target)
+	if got.Error != nil || !got.Result.Attempts[0].Success {
+		t.Fatalf("Detect() = %+v, %v, want success", got.Result.Attempts[0], got.Error)
+	}
+	if got := <-hosts; got != host {
+		t.Errorf("Detect() host of request = %s, want %s", got, host)
+	}
+}
+
+func TestHttpDetector_DetectTiming(t *testing.T) {
+	var server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	var detector = NewHttpDetector(HttpDetectorOptions{DefaultTimeout: 1000, DefaultCount: 3})
+	var target = NewDetectTarget(HTTPDetect, server.URL, DetectOptions[HttpOptions]{Options: HttpOptions{Insecure: true}})
+	got := detector.Detect(target)
+	if got.Error != nil {
+		t.Fatalf("Detect() error = %v", got.Error)
+	}
+	for _, attempt := range got.Result.Attempts {
+		if !attempt.Success || attempt.Timing.Connect <= 0 || attempt.Timing.TLSHandshake <= 0 {
+			t.Errorf("Detect() attempt = %+v, want success with connect and tls timing", attempt)
+		}
+	}
+}
+
+func TestHttpDetector_DetectCancelled(t *testing.T) {
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	var detector = NewHttpDetector(HttpDetectorOptions{DefaultTimeout: 10000, DefaultCount: 3})
+	var ctx, cancel = context.WithCancel(context.Background())
+	var target = NewDetectTarget(HTTPDetect, server.URL, DetectOptions[HttpOptions]{}).WithContext(ctx)
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	var start = time.Now()
+	got := detector.Detect(target.(DetectTarget[HttpOptions]))
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Detect() of cancelled target takes %s", elapsed)
+	}
+	if got.Error != nil || len(got.Result.Attempts) != 1 || got.Result.Successes != 0 {
+		t.Errorf("Detect() = %+v, want one aborted attempt", got.Result)
+	}
+
+	got = detector.Detect(target.(DetectTarget[HttpOptions]))
+	if !errors.Is(got.Error, context.Canceled) {
+		t.Errorf("Detect() error = %v, want %v", got.Error, context.Canceled)
+	}
+}
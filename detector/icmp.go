@@ -6,16 +6,52 @@ import (
 	"fmt"
 	"github.com/go-ping/ping"
 	"github.com/spf13/viper"
+	"net"
 	"time"
 )
 
 type IcmpOptions struct {
 }
 
+// IcmpStatistics ping statistics of target
+type IcmpStatistics struct {
+	*ping.Statistics
+}
+
+func (statistics *IcmpStatistics) Summary() Summary {
+	if statistics == nil || statistics.Statistics == nil {
+		return Summary{}
+	}
+	var summary = NewSummary(statistics.PacketsSent, statistics.PacketsRecv, statistics.Rtts)
+	if !summary.Success {
+		summary.ErrorClass = ErrorTimeout
+	}
+	return summary
+}
+
 type IcmpDetectorOptions struct {
-	DefaultTimeout      int
-	DefaultCount        int
-	MaxRunnerCount      int
+	// DefaultTimeout milliseconds to wait for replies after the last echo
+	// request is sent, it applies per probe instead of bounding the whole
+	// run, which takes up to (count-1)*interval+timeout
+	DefaultTimeout int
+	DefaultCount   int
+	// DefaultInterval milliseconds between echo requests to same target,
+	// 200 is used if it is not positive
+	DefaultInterval int
+	// RateLimit echo requests sent per second of all targets
+	RateLimit int
+	RateBurst int
+	// SocketBuffer bytes of receive buffer of icmp sockets
+	SocketBuffer int
+	// Privileged auto, true or false, raw socket is used if true, datagram
+	// socket if false, auto tries raw socket first
+	Privileged string
+	// MaxRunnerCount targets detected concurrently, runners share sockets
+	// of engine, so it can be much larger than other detectors
+	MaxRunnerCount int
+	// MaxDetectBufferSize targets waiting for idle runner, targets of all
+	// priorities share it, so it is kept small and the rest wait in queues
+	// of priorities of dispatcher
 	MaxDetectBufferSize int
 	MaxResultQueueSize  int
 }
@@ -24,6 +60,11 @@ func NewIcmpDetectorOptions() IcmpDetectorOptions {
 	var options = IcmpDetectorOptions{
 		DefaultTimeout:      viper.GetInt("detector.icmp.detect.timeout"),
 		DefaultCount:        viper.GetInt("detector.icmp.detect.count"),
+		DefaultInterval:     viper.GetInt("detector.icmp.detect.interval"),
+		RateLimit:           viper.GetInt("detector.icmp.rate.limit"),
+		RateBurst:           viper.GetInt("detector.icmp.rate.burst"),
+		SocketBuffer:        viper.GetInt("detector.icmp.socket.buffer"),
+		Privileged:          viper.GetString("detector.icmp.privileged"),
 		MaxRunnerCount:      viper.GetInt("detector.icmp.runner.count"),
 		MaxDetectBufferSize: viper.GetInt("detector.icmp.detect.buffer.size"),
 		MaxResultQueueSize:  viper.GetInt("detector.icmp.detect.result.queue.size"),
@@ -35,8 +76,23 @@ func NewIcmpDetectorOptions() IcmpDetectorOptions {
 	if options.DefaultCount <= 0 {
 		options.DefaultCount = 3
 	}
+	if options.DefaultInterval <= 0 {
+		options.DefaultInterval = 200
+	}
+	if options.RateLimit <= 0 {
+		options.RateLimit = 10000
+	}
+	if options.RateBurst <= 0 {
+		options.RateBurst = 100
+	}
+	if options.SocketBuffer <= 0 {
+		options.SocketBuffer = 4 << 20
+	}
+	if options.Privileged == "" {
+		options.Privileged = icmpModeAuto
+	}
 	if options.MaxDetectBufferSize <= 0 {
-		options.MaxDetectBufferSize = 256
+		options.MaxDetectBufferSize = 1
 	}
 	if options.MaxRunnerCount <= 0 {
 		options.MaxRunnerCount = 10
@@ -45,20 +101,27 @@ func NewIcmpDetectorOptions() IcmpDetectorOptions {
 	return options
 }
 
-// IcmpDetector detect target use icmp protocol
+// IcmpDetector detect target use icmp protocol, echo of all targets are
+// sent and received by the shared engine
 type IcmpDetector struct {
 	options          IcmpDetectorOptions
+	engine           *icmpEngine
 	detectBuffer     chan DetectTarget[IcmpOptions]
-	resultQueue      chan DetectResult[IcmpOptions, *ping.Statistics]
+	resultQueue      chan DetectResult[IcmpOptions, *IcmpStatistics]
 	parentCtx        context.Context
 	parentCancelFunc context.CancelFunc
 }
 
-func NewIcmpDetector(options IcmpDetectorOptions) Detector[IcmpOptions, *ping.Statistics] {
+func NewIcmpDetector(options IcmpDetectorOptions) Detector[IcmpOptions, *IcmpStatistics] {
+	// ticker of engine panics with non-positive interval
+	if options.DefaultInterval <= 0 {
+		options.DefaultInterval = 200
+	}
 	var detector = &IcmpDetector{
 		options:      options,
+		engine:       newIcmpEngine(options.Privileged, options.SocketBuffer, options.RateLimit, options.RateBurst),
 		detectBuffer: make(chan DetectTarget[IcmpOptions], options.MaxDetectBufferSize),
-		resultQueue:  make(chan DetectResult[IcmpOptions, *ping.Statistics], options.MaxResultQueueSize),
+		resultQueue:  make(chan DetectResult[IcmpOptions, *IcmpStatistics], options.MaxResultQueueSize),
 	}
 
 	return detector
@@ -72,6 +135,9 @@ func (detector *IcmpDetector) Start() error {
 	if detector.detectBuffer == nil {
 		return fmt.Errorf("detect queue can not be nil")
 	}
+	if err := detector.engine.start(); err != nil {
+		return err
+	}
 	detector.parentCtx, detector.parentCancelFunc = context.WithCancel(context.Background())
 	for i := 1; i <= detector.options.MaxRunnerCount; i++ {
 		go func(idx int) {
@@ -109,15 +175,21 @@ func (detector *IcmpDetector) Stop() error {
 	}
 	log.Logger.Infof("current length of target queue is 0, stopping detector")
 	detector.parentCancelFunc()
+	detector.engine.stop()
 	return nil
 }
 
-func (detector *IcmpDetector) Detect(target DetectTarget[IcmpOptions]) DetectResult[IcmpOptions, *ping.Statistics] {
-	var result = DetectResult[IcmpOptions, *ping.Statistics]{
+func (detector *IcmpDetector) Detect(target DetectTarget[IcmpOptions]) DetectResult[IcmpOptions, *IcmpStatistics] {
+	var result = DetectResult[IcmpOptions, *IcmpStatistics]{
 		Target: target,
 	}
-	pinger, err := ping.NewPinger(target.Target)
-	pinger.SetPrivileged(true)
+	var ctx, cancel = detectContext(detector.parentCtx, target)
+	defer cancel()
+	if err := ctx.Err(); err != nil {
+		result.Error = err
+		return result
+	}
+	addr, err := net.ResolveIPAddr("ip", target.probeHost())
 	if err != nil {
 		result.Error = err
 		return result
@@ -125,14 +197,15 @@ func (detector *IcmpDetector) Detect(target DetectTarget[IcmpOptions]) DetectRes
 	if target.Options.Count <= 0 {
 		target.Options.Count = detector.options.DefaultCount
 	}
-	pinger.Count = target.Options.Count
-
 	if target.Options.Timeout <= 0 {
 		target.Options.Timeout = detector.options.DefaultTimeout
 	}
-	pinger.Timeout = time.Duration(target.Options.Timeout) * time.Millisecond
-	result.Error = pinger.Run()
-	result.Result = pinger.Statistics()
+
+	statistics, err := detector.engine.ping(ctx, addr, target.Options.Count,
+		time.Duration(detector.options.DefaultInterval)*time.Millisecond,
+		time.Duration(target.Options.Timeout)*time.Millisecond)
+	result.Error = err
+	result.Result = &IcmpStatistics{Statistics: statistics}
 	return result
 }
 
@@ -141,6 +214,6 @@ func (detector *IcmpDetector) Detects() chan<- DetectTarget[IcmpOptions] {
 	return detector.detectBuffer
 }
 
-func (detector *IcmpDetector) Results() <-chan DetectResult[IcmpOptions, *ping.Statistics] {
+func (detector *IcmpDetector) Results() <-chan DetectResult[IcmpOptions, *IcmpStatistics] {
 	return detector.resultQueue
 }
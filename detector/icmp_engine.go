@@ -0,0 +1,358 @@
+package detector
+
+import (
+	"context"
+	"detect-server/log"
+	"encoding/binary"
+	"fmt"
+	"github.com/go-ping/ping"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/time/rate"
+	"math"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	protocolICMP     = 1
+	protocolIPv6ICMP = 58
+)
+
+// icmpProbeKey identify echo request waiting for reply, sequence is shared
+// by all targets, so address is needed to tell replies apart after wrapping
+type icmpProbeKey struct {
+	addr string
+	seq  uint16
+}
+
+// icmpReply echo reply received for probe of ping
+type icmpReply struct {
+	seq      uint16
+	received time.Time
+}
+
+const (
+	icmpModeAuto         = "auto"
+	icmpModePrivileged   = "true"
+	icmpModeUnprivileged = "false"
+)
+
+// icmpSocket socket of address family, echo identifier of unprivileged
+// datagram socket is replaced with its local port by kernel
+type icmpSocket struct {
+	conn       *icmp.PacketConn
+	proto      int
+	id         uint16
+	privileged bool
+}
+
+// icmpEngine send and receive echo of all targets with one socket per
+// address family, replies are matched to waiting probes by identifier,
+// address and sequence
+type icmpEngine struct {
+	id      uint16
+	mode    string
+	buffer  int
+	limiter *rate.Limiter
+	sock4   *icmpSocket
+	sock6   *icmpSocket
+
+	lock    sync.Mutex
+	seq     uint16
+	waiting map[icmpProbeKey]chan<- icmpReply
+}
+
+func newIcmpEngine(mode string, buffer int, limit int, burst int) *icmpEngine {
+	return &icmpEngine{
+		id:      uint16(rand.Intn(math.MaxUint16 + 1)),
+		mode:    mode,
+		buffer:  buffer,
+		limiter: rate.NewLimiter(rate.Limit(limit), burst),
+		waiting: make(map[icmpProbeKey]chan<- icmpReply),
+	}
+}
+
+// start open sockets, ipv6 is optional since many hosts have no ipv6
+func (engine *icmpEngine) start() error {
+	switch engine.mode {
+	case icmpModeAuto, icmpModePrivileged, icmpModeUnprivileged:
+	default:
+		return fmt.Errorf("icmp privileged mode %s is invalid", engine.mode)
+	}
+	sock4, err := engine.listen("ip4:icmp", "udp4", "0.0.0.0", protocolICMP)
+	if err != nil {
+		return fmt.Errorf("listen icmp socket failed. %s", err)
+	}
+	engine.sock4 = sock4
+	engine.setReadBuffer(sock4)
+	go engine.receive(sock4)
+
+	sock6, err := engine.listen("ip6:ipv6-icmp", "udp6", "::", protocolIPv6ICMP)
+	if err != nil {
+		log.Logger.Warnf("listen icmpv6 socket failed, ipv6 targets can not be detected. %s", err)
+		return nil
+	}
+	engine.sock6 = sock6
+	engine.setReadBuffer(sock6)
+	go engine.receive(sock6)
+	return nil
+}
+
+// listen open raw socket of privileged mode or datagram socket of
+// unprivileged mode, auto mode falls back to datagram socket if raw
+// socket is not permitted
+func (engine *icmpEngine) listen(rawNetwork string, udpNetwork string, address string, proto int) (*icmpSocket, error) {
+	if engine.mode != icmpModeUnprivileged {
+		conn, err := icmp.ListenPacket(rawNetwork, address)
+		if err == nil {
+			log.Logger.Infof("icmp detector use privileged raw socket %s", rawNetwork)
+			return &icmpSocket{conn: conn, proto: proto, id: engine.id, privileged: true}, nil
+		}
+		if engine.mode == icmpModePrivileged {
+			return nil, err
+		}
+		log.Logger.Infof("open raw socket %s failed, fall back to unprivileged mode. %s", rawNetwork, err)
+	}
+
+	// permitted by net.ipv4.ping_group_range on linux
+	conn, err := icmp.ListenPacket(udpNetwork, address)
+	if err != nil {
+		return nil, err
+	}
+	var sock = &icmpSocket{conn: conn, proto: proto, id: engine.id}
+	if local, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		sock.id = uint16(local.Port)
+	}
+	log.Logger.Infof("icmp detector use unprivileged datagram socket %s", udpNetwork)
+	return sock, nil
+}
+
+// setReadBuffer enlarge receive buffer of socket, replies of large sweep
+// are dropped by kernel if default buffer is full. size is capped by
+// net.core.rmem_max
+func (engine *icmpEngine) setReadBuffer(sock *icmpSocket) {
+	if engine.buffer <= 0 {
+		return
+	}
+	var conn net.PacketConn
+	if p4 := sock.conn.IPv4PacketConn(); p4 != nil {
+		conn = p4.PacketConn
+	} else if p6 := sock.conn.IPv6PacketConn(); p6 != nil {
+		conn = p6.PacketConn
+	}
+	buffered, ok := conn.(interface{ SetReadBuffer(bytes int) error })
+	if !ok {
+		return
+	}
+	if err := buffered.SetReadBuffer(engine.buffer); err != nil {
+		log.Logger.Warnf("set icmp socket read buffer failed. %s", err)
+	}
+}
+
+func (engine *icmpEngine) stop() {
+	if engine.sock4 != nil {
+		_ = engine.sock4.conn.Close()
+	}
+	if engine.sock6 != nil {
+		_ = engine.sock6.conn.Close()
+	}
+}
+
+// receive read replies until socket is closed
+func (engine *icmpEngine) receive(sock *icmpSocket) {
+	var buf = make([]byte, 1500)
+	for {
+		n, peer, err := sock.conn.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			log.Logger.Debugf("stop receiving icmp replies. %s", err)
+			return
+		}
+		var received = time.Now()
+		message, err := icmp.ParseMessage(sock.proto, buf[:n])
+		if err != nil {
+			continue
+		}
+		if message.Type != ipv4.ICMPTypeEchoReply && message.Type != ipv6.ICMPTypeEchoReply {
+			continue
+		}
+		echo, ok := message.Body.(*icmp.Echo)
+		if !ok || uint16(echo.ID) != sock.id {
+			continue
+		}
+		var addr = peer.String()
+		switch peer := peer.(type) {
+		case *net.IPAddr:
+			addr = peer.IP.String()
+		case *net.UDPAddr:
+			addr = peer.IP.String()
+		}
+		engine.deliver(icmpProbeKey{addr: addr, seq: uint16(echo.Seq)}, received)
+	}
+}
+
+// deliver reply to waiting ping, duplicate replies are dropped
+func (engine *icmpEngine) deliver(key icmpProbeKey, received time.Time) {
+	engine.lock.Lock()
+	replies, ok := engine.waiting[key]
+	delete(engine.waiting, key)
+	engine.lock.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case replies <- icmpReply{seq: key.seq, received: received}:
+	default:
+	}
+}
+
+// register reserve sequence of probe to addr
+func (engine *icmpEngine) register(addr string, replies chan<- icmpReply) uint16 {
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+	for {
+		engine.seq++
+		var key = icmpProbeKey{addr: addr, seq: engine.seq}
+		if _, ok := engine.waiting[key]; !ok {
+			engine.waiting[key] = replies
+			return engine.seq
+		}
+	}
+}
+
+func (engine *icmpEngine) unregister(addr string, seqs []uint16) {
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+	for _, seq := range seqs {
+		delete(engine.waiting, icmpProbeKey{addr: addr, seq: seq})
+	}
+}
+
+// ping send count echo requests to addr every interval, reply of every
+// request is waited at most timeout
+func (engine *icmpEngine) ping(ctx context.Context, addr *net.IPAddr, count int,
+	interval time.Duration, timeout time.Duration) (*ping.Statistics, error) {
+	var (
+		sock               = engine.sock4
+		echoType icmp.Type = ipv4.ICMPTypeEcho
+		key                = addr.IP.String()
+	)
+	if addr.IP.To4() == nil {
+		sock, echoType = engine.sock6, ipv6.ICMPTypeEchoRequest
+	}
+	if sock == nil {
+		return nil, fmt.Errorf("icmp socket of %s is not available", key)
+	}
+	var dst net.Addr = addr
+	if !sock.privileged {
+		dst = &net.UDPAddr{IP: addr.IP, Zone: addr.Zone}
+	}
+
+	var (
+		replies = make(chan icmpReply, count)
+		sent    = make(map[uint16]time.Time, count)
+		seqs    = make([]uint16, 0, count)
+		rtts    = make([]time.Duration, 0, count)
+	)
+	defer func() {
+		engine.unregister(key, seqs)
+	}()
+
+	var (
+		deadline = time.NewTimer(math.MaxInt64)
+		ticker   = time.NewTicker(interval)
+	)
+	defer deadline.Stop()
+	defer ticker.Stop()
+
+	var send = func() error {
+		if err := engine.limiter.Wait(ctx); err != nil {
+			return err
+		}
+		var seq = engine.register(key, replies)
+		seqs = append(seqs, seq)
+		var data = make([]byte, 8)
+		binary.BigEndian.PutUint64(data, uint64(time.Now().UnixNano()))
+		var message = icmp.Message{
+			Type: echoType,
+			Body: &icmp.Echo{ID: int(sock.id), Seq: int(seq), Data: data},
+		}
+		packet, err := message.Marshal(nil)
+		if err != nil {
+			return err
+		}
+		sent[seq] = time.Now()
+		if _, err = sock.conn.WriteTo(packet, dst); err != nil {
+			return err
+		}
+		if len(seqs) == count {
+			ticker.Stop()
+			deadline.Reset(timeout)
+		}
+		return nil
+	}
+
+	if err := send(); err != nil {
+		return nil, err
+	}
+	for len(rtts) < count {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+			if err := send(); err != nil {
+				return nil, err
+			}
+		case reply := <-replies:
+			var rtt = reply.received.Sub(sent[reply.seq])
+			if rtt <= timeout {
+				rtts = append(rtts, rtt)
+			}
+		case <-deadline.C:
+			return newPingStatistics(addr, len(seqs), rtts), nil
+		}
+	}
+	return newPingStatistics(addr, len(seqs), rtts), nil
+}
+
+// newPingStatistics build statistics same as ping.Pinger
+func newPingStatistics(addr *net.IPAddr, sent int, rtts []time.Duration) *ping.Statistics {
+	var statistics = &ping.Statistics{
+		PacketsSent: sent,
+		PacketsRecv: len(rtts),
+		IPAddr:      addr,
+		Addr:        addr.String(),
+		Rtts:        rtts,
+	}
+	if sent > 0 {
+		statistics.PacketLoss = float64(sent-len(rtts)) / float64(sent) * 100
+	}
+	if len(rtts) == 0 {
+		return statistics
+	}
+	var total time.Duration
+	statistics.MinRtt = rtts[0]
+	for _, rtt := range rtts {
+		if rtt < statistics.MinRtt {
+			statistics.MinRtt = rtt
+		}
+		if rtt > statistics.MaxRtt {
+			statistics.MaxRtt = rtt
+		}
+		total += rtt
+	}
+	statistics.AvgRtt = total / time.Duration(len(rtts))
+	var variance float64
+	for _, rtt := range rtts {
+		var diff = float64(rtt - statistics.AvgRtt)
+		variance += diff * diff
+	}
+	statistics.StdDevRtt = time.Duration(math.Sqrt(variance / float64(len(rtts))))
+	return statistics
+}
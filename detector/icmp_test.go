@@ -2,60 +2,169 @@ package detector
 
 import (
 	"context"
-	"github.com/go-ping/ping"
-	"reflect"
+	"detect-server/log"
+	"go.uber.org/zap"
+	"net"
+	"sync"
 	"testing"
+	"time"
 )
 
+func init() {
+	log.Logger = zap.NewNop().Sugar()
+}
+
 func TestIcmpDetector_Detect(t *testing.T) {
 	type fields struct {
 		options          IcmpDetectorOptions
-		detectBuffer     chan DetectTarget[IcmpDetectOptions]
-		resultQueue      chan DetectResult[IcmpDetectOptions, *ping.Statistics]
+		engine           *icmpEngine
+		detectBuffer     chan DetectTarget[IcmpOptions]
+		resultQueue      chan DetectResult[IcmpOptions, *IcmpStatistics]
 		parentCtx        context.Context
 		parentCancelFunc context.CancelFunc
 	}
 	type args struct {
-		target DetectTarget[IcmpDetectOptions]
+		target DetectTarget[IcmpOptions]
 	}
 
-	var target = NewDetectTarget[IcmpDetectOptions](ICMPDetect, IcmpDetectOptions{
-		Target:  "192.168.0.1",
-		Count:   3,
+	var target = NewDetectTarget[IcmpOptions](ICMPDetect, "127.0.0.1", DetectOptions[IcmpOptions]{
+		Count:   1,
 		Timeout: 500,
 	})
 
 	tests := []struct {
-		name   string
-		fields fields
-		args   args
-		want   DetectResult[IcmpDetectOptions, *ping.Statistics]
+		name     string
+		fields   fields
+		args     args
+		wantRecv int
+		wantErr  bool
 	}{
 		{
-			name: "192.168.0.1",
+			name: "127.0.0.1",
 			fields: fields{
-				options:          IcmpDetectorOptions{},
-				detectBuffer:     make(chan DetectTarget[IcmpDetectOptions], 10),
-				resultQueue:      make(chan DetectResult[IcmpDetectOptions, *ping.Statistics], 10),
+				options:          IcmpDetectorOptions{DefaultInterval: 100},
+				engine:           newIcmpEngine(icmpModeAuto, 0, 100, 10),
+				detectBuffer:     make(chan DetectTarget[IcmpOptions], 10),
+				resultQueue:      make(chan DetectResult[IcmpOptions, *IcmpStatistics], 10),
 				parentCtx:        nil,
 				parentCancelFunc: nil,
 			},
-			args: args{target: target},
-			want: NewDetectResult[IcmpDetectOptions, *ping.Statistics](target, &ping.Statistics{}, nil),
+			args:     args{target: target},
+			wantRecv: 1,
+			wantErr:  false,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			detector := &IcmpDetector{
 				options:          tt.fields.options,
+				engine:           tt.fields.engine,
 				detectBuffer:     tt.fields.detectBuffer,
 				resultQueue:      tt.fields.resultQueue,
 				parentCtx:        tt.fields.parentCtx,
 				parentCancelFunc: tt.fields.parentCancelFunc,
 			}
-			if got := detector.Detect(tt.args.target); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("Detect() = %v, want %v", got, tt.want)
+			if err := detector.engine.start(); err != nil {
+				t.Skipf("open icmp socket failed. %s", err)
+			}
+			defer detector.engine.stop()
+			got := detector.Detect(tt.args.target)
+			if (got.Error != nil) != tt.wantErr {
+				t.Errorf("Detect() error = %v, wantErr %v", got.Error, tt.wantErr)
+				return
+			}
+			if got.Result.PacketsRecv != tt.wantRecv {
+				t.Errorf("Detect() received = %v, want %v", got.Result.PacketsRecv, tt.wantRecv)
 			}
 		})
 	}
 }
+
+func TestNewIcmpDetector_DefaultInterval(t *testing.T) {
+	// options built directly without interval must not panic the engine
+	var detector = NewIcmpDetector(IcmpDetectorOptions{Privileged: icmpModeAuto, RateLimit: 100, RateBurst: 10,
+		MaxRunnerCount: 1, MaxDetectBufferSize: 1, MaxResultQueueSize: 1})
+	if err := detector.Start(); err != nil {
+		t.Skipf("open icmp socket failed. %s", err)
+	}
+	defer detector.Stop()
+	var got = detector.Detect(NewDetectTarget[IcmpOptions](ICMPDetect, "127.0.0.1", DetectOptions[IcmpOptions]{
+		Count:   2,
+		Timeout: 500,
+	}))
+	if got.Error != nil || got.Result.PacketsRecv != 2 {
+		t.Errorf("Detect() = %+v, %v, want 2 replies", got.Result, got.Error)
+	}
+}
+
+func TestIcmpEngine_Ping(t *testing.T) {
+	for _, mode := range []string{icmpModePrivileged, icmpModeUnprivileged} {
+		t.Run(mode, func(t *testing.T) {
+			testIcmpEnginePing(t, mode)
+		})
+	}
+}
+
+func testIcmpEnginePing(t *testing.T, mode string) {
+	var engine = newIcmpEngine(mode, 1<<20, 1000, 100)
+	if err := engine.start(); err != nil {
+		t.Skipf("open icmp socket failed. %s", err)
+	}
+	defer engine.stop()
+
+	tests := []struct {
+		name     string
+		addr     string
+		count    int
+		wantRecv int
+	}{
+		{name: "loopback", addr: "127.0.0.1", count: 3, wantRecv: 3},
+		{name: "loopback other", addr: "127.0.0.2", count: 2, wantRecv: 2},
+	}
+	// targets are pinged concurrently through the shared socket
+	var wg sync.WaitGroup
+	for _, tt := range tests {
+		tt := tt
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := engine.ping(context.Background(), &net.IPAddr{IP: net.ParseIP(tt.addr)},
+				tt.count, 50*time.Millisecond, 300*time.Millisecond)
+			if err != nil {
+				t.Errorf("%s: ping() error = %v", tt.name, err)
+				return
+			}
+			if got.PacketsSent != tt.count || got.PacketsRecv != tt.wantRecv {
+				t.Errorf("%s: ping() sent = %d, received = %d, want %d, %d",
+					tt.name, got.PacketsSent, got.PacketsRecv, tt.count, tt.wantRecv)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(engine.waiting) != 0 {
+		t.Errorf("waiting probes = %d, want 0", len(engine.waiting))
+	}
+}
+
+func TestIcmpEngine_InvalidMode(t *testing.T) {
+	if err := newIcmpEngine("yes", 0, 100, 10).start(); err == nil {
+		t.Errorf("start() error = nil, want invalid mode error")
+	}
+}
+
+func TestNewPingStatistics(t *testing.T) {
+	var addr = &net.IPAddr{IP: net.ParseIP("192.0.2.1")}
+	got := newPingStatistics(addr, 4, []time.Duration{10 * time.Millisecond, 30 * time.Millisecond})
+	if got.PacketsSent != 4 || got.PacketsRecv != 2 || got.PacketLoss != 50 {
+		t.Errorf("newPingStatistics() sent = %d, received = %d, loss = %v", got.PacketsSent, got.PacketsRecv, got.PacketLoss)
+	}
+	if got.MinRtt != 10*time.Millisecond || got.MaxRtt != 30*time.Millisecond ||
+		got.AvgRtt != 20*time.Millisecond || got.StdDevRtt != 10*time.Millisecond {
+		t.Errorf("newPingStatistics() rtt = %v/%v/%v/%v", got.MinRtt, got.AvgRtt, got.MaxRtt, got.StdDevRtt)
+	}
+
+	got = newPingStatistics(addr, 3, nil)
+	if got.PacketLoss != 100 || got.MinRtt != 0 {
+		t.Errorf("newPingStatistics() loss = %v, min = %v", got.PacketLoss, got.MinRtt)
+	}
+}
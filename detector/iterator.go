@@ -0,0 +1,92 @@
+package detector
+
+// TargetIterator yield targets lazily, so targets of large subnet are only
+// created when they are consumed. iterator is not safe for concurrent use
+type TargetIterator interface {
+	// Next return next target, ok is false when targets are exhausted
+	Next() (target Target, ok bool)
+	// Count number of all targets of iterator, consumed or not
+	Count() int
+}
+
+type sliceIterator struct {
+	targets []Target
+	index   int
+}
+
+// NewSliceIterator iterate targets already created
+func NewSliceIterator(targets ...Target) TargetIterator {
+	return &sliceIterator{targets: targets}
+}
+
+func (iterator *sliceIterator) Next() (Target, bool) {
+	if iterator.index >= len(iterator.targets) {
+		return nil, false
+	}
+	var target = iterator.targets[iterator.index]
+	iterator.index++
+	return target, true
+}
+
+func (iterator *sliceIterator) Count() int {
+	return len(iterator.targets)
+}
+
+type chainIterator struct {
+	iterators []TargetIterator
+	count     int
+}
+
+// ChainIterators iterate targets of all iterators in order
+func ChainIterators(iterators ...TargetIterator) TargetIterator {
+	var chain = &chainIterator{iterators: iterators}
+	for _, iterator := range iterators {
+		chain.count += iterator.Count()
+	}
+	return chain
+}
+
+func (chain *chainIterator) Next() (Target, bool) {
+	for len(chain.iterators) > 0 {
+		if target, ok := chain.iterators[0].Next(); ok {
+			return target, true
+		}
+		chain.iterators = chain.iterators[1:]
+	}
+	return nil, false
+}
+
+func (chain *chainIterator) Count() int {
+	return chain.count
+}
+
+type mapIterator struct {
+	iterator TargetIterator
+	fn       func(Target) Target
+}
+
+// MapIterator apply fn to every target of iterator when it is consumed
+func MapIterator(iterator TargetIterator, fn func(Target) Target) TargetIterator {
+	return &mapIterator{iterator: iterator, fn: fn}
+}
+
+func (m *mapIterator) Next() (Target, bool) {
+	target, ok := m.iterator.Next()
+	if !ok {
+		return nil, false
+	}
+	return m.fn(target), true
+}
+
+func (m *mapIterator) Count() int {
+	return m.iterator.Count()
+}
+
+// CollectTargets consume all targets of iterator
+func CollectTargets(iterator TargetIterator) []Target {
+	var targets = make([]Target, 0, iterator.Count())
+	for target, ok := iterator.Next(); ok; target, ok = iterator.Next() {
+		targets = append(targets, target)
+	}
+	return targets
+}
@@ -0,0 +1,117 @@
+package detector
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"syscall"
+	"time"
+)
+
+// ErrorClass protocol independent category of detect error
+type ErrorClass = string
+
+const (
+	ErrorNone        ErrorClass = ""
+	ErrorTimeout     ErrorClass = "timeout"
+	ErrorRefused     ErrorClass = "refused"
+	ErrorUnreachable ErrorClass = "unreachable"
+	ErrorResolve     ErrorClass = "resolve"
+	ErrorTLS         ErrorClass = "tls"
+	ErrorAssertion   ErrorClass = "assertion"
+	ErrorFiltered    ErrorClass = "filtered"
+	ErrorUnknown     ErrorClass = "unknown"
+)
+
+// ErrTargetFiltered target is not allowed to be detected by filter
+var ErrTargetFiltered = errors.New("target is filtered")
+
+// Summary fields shared by results of all protocols, Sent and Received
+// count probes of the protocol, e.g. icmp echo, tcp connect or http request
+type Summary struct {
+	Success    bool
+	Sent       int
+	Received   int
+	Loss       float64
+	Latencies  []time.Duration
+	MinLatency time.Duration
+	AvgLatency time.Duration
+	MaxLatency time.Duration
+	ErrorClass ErrorClass
+}
+
+// NewSummary create summary and calculate loss and latency statistics
+func NewSummary(sent int, received int, latencies []time.Duration) Summary {
+	var summary = Summary{
+		Success:   received > 0,
+		Sent:      sent,
+		Received:  received,
+		Latencies: latencies,
+	}
+	if sent > 0 {
+		summary.Loss = float64(sent-received) / float64(sent) * 100
+	}
+	var total time.Duration
+	for _, latency := range latencies {
+		total += latency
+		if summary.MinLatency == 0 || latency < summary.MinLatency {
+			summary.MinLatency = latency
+		}
+		if latency > summary.MaxLatency {
+			summary.MaxLatency = latency
+		}
+	}
+	if len(latencies) > 0 {
+		summary.AvgLatency = total / time.Duration(len(latencies))
+	}
+	return summary
+}
+
+// ClassifyError map error of detect to ErrorClass
+func ClassifyError(err error) ErrorClass {
+	if err == nil {
+		return ErrorNone
+	}
+	if errors.Is(err, ErrTargetFiltered) {
+		return ErrorFiltered
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		if dnsErr.IsTimeout {
+			return ErrorTimeout
+		}
+		return ErrorResolve
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return ErrorRefused
+	}
+	if errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH) {
+		return ErrorUnreachable
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrorTimeout
+	}
+	var recordErr tls.RecordHeaderError
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &recordErr) || errors.As(err, &certErr) || errors.As(err, &unknownAuthorityErr) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) {
+		return ErrorTLS
+	}
+	return ErrorUnknown
+}
+
+// Summary return summary of result, detect error overrides the summary
+// of protocol result
+func (result DetectResult[T, R]) Summary() Summary {
+	var summary = result.Result.Summary()
+	if result.Error != nil {
+		summary.Success = false
+		summary.ErrorClass = ClassifyError(result.Error)
+	}
+	return summary
+}
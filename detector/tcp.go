@@ -1,4 +1,253 @@
 package detector
 
+import (
+	"context"
+	"detect-server/log"
+	"fmt"
+	"github.com/spf13/viper"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
 type TcpOptions struct {
+	Ports []int
+}
+
+// TcpPortStatistics connect statistics of single port
+type TcpPortStatistics struct {
+	Port       int
+	Attempts   int
+	Successes  int
+	Open       bool
+	Latencies  []time.Duration
+	MinLatency time.Duration
+	MaxLatency time.Duration
+	AvgLatency time.Duration
+	ErrorClass ErrorClass
+	Error      string
+}
+
+// TcpStatistics connect statistics of all ports of target
+type TcpStatistics struct {
+	Addr  string
+	Ports []*TcpPortStatistics
+}
+
+// Summary target is success only when all ports are open
+func (statistics *TcpStatistics) Summary() Summary {
+	if statistics == nil {
+		return Summary{}
+	}
+	var sent, received int
+	var latencies = make([]time.Duration, 0)
+	var errorClass = ErrorNone
+	var allOpen = len(statistics.Ports) > 0
+	for _, port := range statistics.Ports {
+		sent += port.Attempts
+		received += port.Successes
+		latencies = append(latencies, port.Latencies...)
+		if !port.Open {
+			allOpen = false
+			if errorClass == ErrorNone {
+				errorClass = port.ErrorClass
+			}
+		}
+	}
+	var summary = NewSummary(sent, received, latencies)
+	summary.Success = allOpen
+	summary.ErrorClass = errorClass
+	return summary
+}
+
+type TcpDetectorOptions struct {
+	DefaultTimeout      int
+	DefaultCount        int
+	MaxRunnerCount      int
+	MaxDetectBufferSize int
+	MaxResultQueueSize  int
+}
+
+func NewTcpDetectorOptions() TcpDetectorOptions {
+	var options = TcpDetectorOptions{
+		DefaultTimeout:      viper.GetInt("detector.tcp.detect.timeout"),
+		DefaultCount:        viper.GetInt("detector.tcp.detect.count"),
+		MaxRunnerCount:      viper.GetInt("detector.tcp.runner.count"),
+		MaxDetectBufferSize: viper.GetInt("detector.tcp.detect.buffer.size"),
+		MaxResultQueueSize:  viper.GetInt("detector.tcp.detect.result.queue.size"),
+	}
+
+	if options.DefaultTimeout <= 0 {
+		options.DefaultTimeout = 1000
+	}
+	if options.DefaultCount <= 0 {
+		options.DefaultCount = 1
+	}
+	if options.MaxDetectBufferSize <= 0 {
+		options.MaxDetectBufferSize = 1
+	}
+	if options.MaxRunnerCount <= 0 {
+		options.MaxRunnerCount = 10
+	}
+
+	return options
+}
+
+// TcpDetector detect target ports use tcp connect
+type TcpDetector struct {
+	options          TcpDetectorOptions
+	detectBuffer     chan DetectTarget[TcpOptions]
+	resultQueue      chan DetectResult[TcpOptions, *TcpStatistics]
+	parentCtx        context.Context
+	parentCancelFunc context.CancelFunc
+}
+
+func NewTcpDetector(options TcpDetectorOptions) Detector[TcpOptions, *TcpStatistics] {
+	var detector = &TcpDetector{
+		options:      options,
+		detectBuffer: make(chan DetectTarget[TcpOptions], options.MaxDetectBufferSize),
+		resultQueue:  make(chan DetectResult[TcpOptions, *TcpStatistics], options.MaxResultQueueSize),
+	}
+
+	return detector
+}
+
+func (detector *TcpDetector) Start() error {
+	if detector.resultQueue == nil {
+		return fmt.Errorf("result queue can not be nil")
+	}
+
+	if detector.detectBuffer == nil {
+		return fmt.Errorf("detect queue can not be nil")
+	}
+	detector.parentCtx, detector.parentCancelFunc = context.WithCancel(context.Background())
+	for i := 1; i <= detector.options.MaxRunnerCount; i++ {
+		go func(idx int) {
+			var runnerName = fmt.Sprintf("runner%d", idx)
+			log.Logger.Debugf("start tcp detector runner %s", runnerName)
+			err := detector.startRunner(runnerName)
+			if err != nil {
+				log.Logger.Errorf("%s", err)
+			}
+		}(i)
+	}
+	return nil
+}
+
+func (detector *TcpDetector) startRunner(name string) error {
+	var ctx, cancelFunc = context.WithCancel(detector.parentCtx)
+	defer cancelFunc()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Logger.Infof("stopping runner %s", name)
+			return nil
+		case detect := <-detector.detectBuffer:
+			var result = detector.Detect(detect)
+			detector.resultQueue <- result
+		}
+	}
+}
+
+func (detector *TcpDetector) Stop() error {
+	for length := len(detector.detectBuffer); length > 0; length = len(detector.detectBuffer) {
+		time.Sleep(time.Second)
+	}
+	log.Logger.Infof("current length of target queue is 0, stopping detector")
+	detector.parentCancelFunc()
+	return nil
+}
+
+// Detect connect all ports of target, ports are detected concurrently
+// and every port is connected Count times
+func (detector *TcpDetector) Detect(target DetectTarget[TcpOptions]) DetectResult[TcpOptions, *TcpStatistics] {
+	var result = DetectResult[TcpOptions, *TcpStatistics]{
+		Target: target,
+	}
+	var ctx, cancel = detectContext(detector.parentCtx, target)
+	defer cancel()
+	if err := ctx.Err(); err != nil {
+		result.Error = err
+		return result
+	}
+	if len(target.Options.Options.Ports) == 0 {
+		result.Error = fmt.Errorf("no port to detect for target %s", target.Target)
+		return result
+	}
+	if target.Options.Count <= 0 {
+		target.Options.Count = detector.options.DefaultCount
+	}
+	if target.Options.Timeout <= 0 {
+		target.Options.Timeout = detector.options.DefaultTimeout
+	}
+	var timeout = time.Duration(target.Options.Timeout) * time.Millisecond
+
+	var statistics = &TcpStatistics{
+		Addr:  target.Target,
+		Ports: make([]*TcpPortStatistics, len(target.Options.Options.Ports)),
+	}
+	var wg sync.WaitGroup
+	for i, port := range target.Options.Options.Ports {
+		wg.Add(1)
+		go func(idx int, port int) {
+			defer wg.Done()
+			statistics.Ports[idx] = connectPort(ctx, target.probeHost(), port, target.Options.Count, timeout)
+		}(i, port)
+	}
+	wg.Wait()
+
+	result.Target = target
+	result.Result = statistics
+	return result
+}
+
+// connectPort try to connect port count times, latency of every
+// successful attempt is recorded, attempts stop when ctx is done
+func connectPort(ctx context.Context, host string, port int, count int, timeout time.Duration) *TcpPortStatistics {
+	var statistics = &TcpPortStatistics{
+		Port:      port,
+		Latencies: make([]time.Duration, 0, count),
+	}
+	var address = net.JoinHostPort(host, strconv.Itoa(port))
+	var total time.Duration
+	var dialer = net.Dialer{Timeout: timeout}
+	for i := 0; i < count && ctx.Err() == nil; i++ {
+		statistics.Attempts++
+		var start = time.Now()
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			statistics.Error = err.Error()
+			statistics.ErrorClass = ClassifyError(err)
+			continue
+		}
+		var latency = time.Since(start)
+		_ = conn.Close()
+
+		statistics.Successes++
+		statistics.Latencies = append(statistics.Latencies, latency)
+		total += latency
+		if statistics.MinLatency == 0 || latency < statistics.MinLatency {
+			statistics.MinLatency = latency
+		}
+		if latency > statistics.MaxLatency {
+			statistics.MaxLatency = latency
+		}
+	}
+	if statistics.Successes > 0 {
+		statistics.Open = true
+		statistics.ErrorClass = ErrorNone
+		statistics.AvgLatency = total / time.Duration(statistics.Successes)
+	}
+	return statistics
+}
+
+// Detects detect target async
+func (detector *TcpDetector) Detects() chan<- DetectTarget[TcpOptions] {
+	return detector.detectBuffer
+}
+
+func (detector *TcpDetector) Results() <-chan DetectResult[TcpOptions, *TcpStatistics] {
+	return detector.resultQueue
 }
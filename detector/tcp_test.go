@@ -0,0 +1,100 @@
+package detector
+
+import (
+	"net"
+	"testing"
+)
+
+func TestTcpDetector_Detect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed. %s", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+	var openPort = listener.Addr().(*net.TCPAddr).Port
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed. %s", err)
+	}
+	var closedPort = closed.Addr().(*net.TCPAddr).Port
+	_ = closed.Close()
+
+	tests := []struct {
+		name     string
+		ports    []int
+		wantOpen []bool
+		wantErr  bool
+	}{
+		{name: "open", ports: []int{openPort}, wantOpen: []bool{true}},
+		{name: "closed", ports: []int{closedPort}, wantOpen: []bool{false}},
+		{name: "mixed", ports: []int{openPort, closedPort}, wantOpen: []bool{true, false}},
+		{name: "no ports", ports: nil, wantErr: true},
+	}
+	var detector = NewTcpDetector(TcpDetectorOptions{DefaultTimeout: 500, DefaultCount: 2})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var target = NewDetectTarget(TCPDetect, "127.0.0.1", DetectOptions[TcpOptions]{
+				Options: TcpOptions{Ports: tt.ports},
+			})
+			got := detector.Detect(target)
+			if (got.Error != nil) != tt.wantErr {
+				t.Errorf("Detect() error = %v, wantErr %v", got.Error, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			for i, port := range got.Result.Ports {
+				if port.Open != tt.wantOpen[i] {
+					t.Errorf("Detect() port %d open = %v, want %v", port.Port, port.Open, tt.wantOpen[i])
+				}
+				if port.Attempts != 2 {
+					t.Errorf("Detect() port %d attempts = %v, want 2", port.Port, port.Attempts)
+				}
+				if port.Open && len(port.Latencies) != 2 {
+					t.Errorf("Detect() port %d latencies = %v, want 2", port.Port, len(port.Latencies))
+				}
+			}
+		})
+	}
+}
+
+func TestTcpDetector_DetectResolved(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed. %s", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	// hostname is not resolved again when it is resolved by filter
+	var detector = NewTcpDetector(TcpDetectorOptions{DefaultTimeout: 500, DefaultCount: 1})
+	var target = NewDetectTarget(TCPDetect, "unknown.invalid", DetectOptions[TcpOptions]{
+		Options: TcpOptions{Ports: []int{listener.Addr().(*net.TCPAddr).Port}},
+	}).WithResolved([]net.IP{net.ParseIP("::1"), net.ParseIP("127.0.0.1")}).(DetectTarget[TcpOptions])
+	got := detector.Detect(target)
+	if got.Error != nil || !got.Result.Ports[0].Open {
+		t.Errorf("Detect() = %+v, %v, want port open at resolved ipv4", got.Result.Ports[0], got.Error)
+	}
+	if got.Result.Addr != "unknown.invalid" {
+		t.Errorf("Detect() addr = %s, want target address", got.Result.Addr)
+	}
+}
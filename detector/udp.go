@@ -1,4 +1,363 @@
 package detector
 
+import (
+	"context"
+	"detect-server/log"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	UdpPortOpen         DetectStatus = "open"
+	UdpPortClosed       DetectStatus = "closed"
+	UdpPortOpenFiltered DetectStatus = "open|filtered"
+	// UdpPortError probe can not be sent or failed other than no reply,
+	// e.g. host can not be resolved or is unreachable
+	UdpPortError DetectStatus = "error"
+)
+
+// udpTemplate built-in payload of well known udp service
+type udpTemplate struct {
+	port    int
+	payload []byte
+}
+
+var udpTemplates = map[string]udpTemplate{
+	// standard query of root NS record
+	"dns": {
+		port: 53,
+		payload: []byte{
+			0x12, 0x34, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x02, 0x00, 0x01,
+		},
+	},
+	// ntp v3 client mode request
+	"ntp": {
+		port:    123,
+		payload: append([]byte{0x1b}, make([]byte, 47)...),
+	},
+	// snmp v1 get-request of sysDescr.0 with community public
+	"snmp": {
+		port: 161,
+		payload: []byte{
+			0x30, 0x26, 0x02, 0x01, 0x00, 0x04, 0x06, 0x70, 0x75, 0x62, 0x6c, 0x69,
+			0x63, 0xa0, 0x19, 0x02, 0x01, 0x01, 0x02, 0x01, 0x00, 0x02, 0x01, 0x00,
+			0x30, 0x0e, 0x30, 0x0c, 0x06, 0x08, 0x2b, 0x06, 0x01, 0x02, 0x01, 0x01,
+			0x01, 0x00, 0x05, 0x00,
+		},
+	},
+}
+
+// UdpOptions Payload is hex encoded raw payload, Template is name of
+// built-in payload, Payload take precedence over Template, empty datagram
+// is sent if both are empty. if Ports is empty, default port of Template
+// will be used
 type UdpOptions struct {
+	Ports    []int
+	Payload  string
+	Template string
+}
+
+// BuildPayload return the bytes will be sent to target
+func (options UdpOptions) BuildPayload() ([]byte, error) {
+	if options.Payload != "" {
+		data, err := hex.DecodeString(strings.TrimPrefix(options.Payload, "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex payload. %s", err)
+		}
+		return data, nil
+	}
+	if options.Template != "" {
+		template, ok := udpTemplates[strings.ToLower(options.Template)]
+		if !ok {
+			return nil, fmt.Errorf("unknown payload template %s", options.Template)
+		}
+		return template.payload, nil
+	}
+	return []byte{}, nil
+}
+
+// DetectPorts return ports will be detected
+func (options UdpOptions) DetectPorts() []int {
+	if len(options.Ports) > 0 {
+		return options.Ports
+	}
+	if template, ok := udpTemplates[strings.ToLower(options.Template)]; ok {
+		return []int{template.port}
+	}
+	return nil
+}
+
+// UdpPortStatistics probe statistics of single port
+type UdpPortStatistics struct {
+	Port         int
+	State        DetectStatus
+	Attempts     int
+	Latency      time.Duration
+	ResponseSize int
+	ErrorClass   ErrorClass
+	Error        string
+}
+
+// UdpStatistics probe statistics of all ports of target
+type UdpStatistics struct {
+	Addr  string
+	Ports []*UdpPortStatistics
+}
+
+// Summary target is success only when all ports replied, closed port
+// is classified as refused, no reply as timeout and failed probe by its
+// error, refused and failed probe take precedence over timeout
+func (statistics *UdpStatistics) Summary() Summary {
+	if statistics == nil {
+		return Summary{}
+	}
+	var sent, received int
+	var latencies = make([]time.Duration, 0)
+	var errorClass = ErrorNone
+	for _, port := range statistics.Ports {
+		sent += port.Attempts
+		switch port.State {
+		case UdpPortOpen:
+			received++
+			latencies = append(latencies, port.Latency)
+		case UdpPortClosed:
+			if errorClass == ErrorNone || errorClass == ErrorTimeout {
+				errorClass = ErrorRefused
+			}
+		case UdpPortError:
+			if errorClass == ErrorNone || errorClass == ErrorTimeout {
+				errorClass = port.ErrorClass
+			}
+		default:
+			if errorClass == ErrorNone {
+				errorClass = ErrorTimeout
+			}
+		}
+	}
+	var summary = NewSummary(sent, received, latencies)
+	summary.Success = len(statistics.Ports) > 0 && received == len(statistics.Ports)
+	summary.ErrorClass = errorClass
+	return summary
+}
+
+type UdpDetectorOptions struct {
+	DefaultTimeout      int
+	DefaultCount        int
+	MaxRunnerCount      int
+	MaxDetectBufferSize int
+	MaxResultQueueSize  int
+}
+
+func NewUdpDetectorOptions() UdpDetectorOptions {
+	var options = UdpDetectorOptions{
+		DefaultTimeout:      viper.GetInt("detector.udp.detect.timeout"),
+		DefaultCount:        viper.GetInt("detector.udp.detect.count"),
+		MaxRunnerCount:      viper.GetInt("detector.udp.runner.count"),
+		MaxDetectBufferSize: viper.GetInt("detector.udp.detect.buffer.size"),
+		MaxResultQueueSize:  viper.GetInt("detector.udp.detect.result.queue.size"),
+	}
+
+	if options.DefaultTimeout <= 0 {
+		options.DefaultTimeout = 1000
+	}
+	if options.DefaultCount <= 0 {
+		options.DefaultCount = 2
+	}
+	if options.MaxDetectBufferSize <= 0 {
+		options.MaxDetectBufferSize = 1
+	}
+	if options.MaxRunnerCount <= 0 {
+		options.MaxRunnerCount = 10
+	}
+
+	return options
+}
+
+// UdpDetector detect target ports by sending udp payload
+type UdpDetector struct {
+	options          UdpDetectorOptions
+	detectBuffer     chan DetectTarget[UdpOptions]
+	resultQueue      chan DetectResult[UdpOptions, *UdpStatistics]
+	parentCtx        context.Context
+	parentCancelFunc context.CancelFunc
+}
+
+func NewUdpDetector(options UdpDetectorOptions) Detector[UdpOptions, *UdpStatistics] {
+	var detector = &UdpDetector{
+		options:      options,
+		detectBuffer: make(chan DetectTarget[UdpOptions], options.MaxDetectBufferSize),
+		resultQueue:  make(chan DetectResult[UdpOptions, *UdpStatistics], options.MaxResultQueueSize),
+	}
+
+	return detector
+}
+
+func (detector *UdpDetector) Start() error {
+	if detector.resultQueue == nil {
+		return fmt.Errorf("result queue can not be nil")
+	}
+
+	if detector.detectBuffer == nil {
+		return fmt.Errorf("detect queue can not be nil")
+	}
+	detector.parentCtx, detector.parentCancelFunc = context.WithCancel(context.Background())
+	for i := 1; i <= detector.options.MaxRunnerCount; i++ {
+		go func(idx int) {
+			var runnerName = fmt.Sprintf("runner%d", idx)
+			log.Logger.Debugf("start udp detector runner %s", runnerName)
+			err := detector.startRunner(runnerName)
+			if err != nil {
+				log.Logger.Errorf("%s", err)
+			}
+		}(i)
+	}
+	return nil
+}
+
+func (detector *UdpDetector) startRunner(name string) error {
+	var ctx, cancelFunc = context.WithCancel(detector.parentCtx)
+	defer cancelFunc()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Logger.Infof("stopping runner %s", name)
+			return nil
+		case detect := <-detector.detectBuffer:
+			var result = detector.Detect(detect)
+			detector.resultQueue <- result
+		}
+	}
+}
+
+func (detector *UdpDetector) Stop() error {
+	for length := len(detector.detectBuffer); length > 0; length = len(detector.detectBuffer) {
+		time.Sleep(time.Second)
+	}
+	log.Logger.Infof("current length of target queue is 0, stopping detector")
+	detector.parentCancelFunc()
+	return nil
+}
+
+// Detect probe all ports of target concurrently, every port is probed
+// at most Count times until a reply or port unreachable is received
+func (detector *UdpDetector) Detect(target DetectTarget[UdpOptions]) DetectResult[UdpOptions, *UdpStatistics] {
+	var result = DetectResult[UdpOptions, *UdpStatistics]{
+		Target: target,
+	}
+	var ctx, cancel = detectContext(detector.parentCtx, target)
+	defer cancel()
+	if err := ctx.Err(); err != nil {
+		result.Error = err
+		return result
+	}
+	var ports = target.Options.Options.DetectPorts()
+	if len(ports) == 0 {
+		result.Error = fmt.Errorf("no port to detect for target %s", target.Target)
+		return result
+	}
+	payload, err := target.Options.Options.BuildPayload()
+	if err != nil {
+		result.Error = err
+		return result
+	}
+	if target.Options.Count <= 0 {
+		target.Options.Count = detector.options.DefaultCount
+	}
+	if target.Options.Timeout <= 0 {
+		target.Options.Timeout = detector.options.DefaultTimeout
+	}
+	var timeout = time.Duration(target.Options.Timeout) * time.Millisecond
+
+	var statistics = &UdpStatistics{
+		Addr:  target.Target,
+		Ports: make([]*UdpPortStatistics, len(ports)),
+	}
+	var wg sync.WaitGroup
+	for i, port := range ports {
+		wg.Add(1)
+		go func(idx int, port int) {
+			defer wg.Done()
+			statistics.Ports[idx] = probeUdpPort(ctx, target.probeHost(), port, payload, target.Options.Count, timeout)
+		}(i, port)
+	}
+	wg.Wait()
+
+	result.Target = target
+	result.Result = statistics
+	return result
+}
+
+// probeUdpPort send payload through a connected udp socket, so icmp port
+// unreachable reported by kernel can be read as connection refused, probes
+// stop when ctx is done. port is in error state if probe fails for reason
+// other than no reply, so it is not taken as open|filtered
+func probeUdpPort(ctx context.Context, host string, port int, payload []byte, count int, timeout time.Duration) *UdpPortStatistics {
+	var statistics = &UdpPortStatistics{
+		Port:  port,
+		State: UdpPortOpenFiltered,
+	}
+	var dialer = net.Dialer{Timeout: timeout}
+	var fail = func(err error) *UdpPortStatistics {
+		statistics.State = UdpPortError
+		statistics.ErrorClass = ClassifyError(err)
+		statistics.Error = err.Error()
+		return statistics
+	}
+	conn, err := dialer.DialContext(ctx, "udp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return fail(err)
+	}
+	defer conn.Close()
+
+	var buffer = make([]byte, 65535)
+	for i := 0; i < count && ctx.Err() == nil; i++ {
+		statistics.Attempts++
+		var start = time.Now()
+		if _, err = conn.Write(payload); err != nil {
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				statistics.State = UdpPortClosed
+				return statistics
+			}
+			return fail(err)
+		}
+		_ = conn.SetReadDeadline(start.Add(timeout))
+		n, err := conn.Read(buffer)
+		if err == nil {
+			statistics.State = UdpPortOpen
+			statistics.Latency = time.Since(start)
+			statistics.ResponseSize = n
+			statistics.Error = ""
+			return statistics
+		}
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			statistics.State = UdpPortClosed
+			statistics.Latency = time.Since(start)
+			statistics.Error = ""
+			return statistics
+		}
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			return fail(err)
+		}
+	}
+	return statistics
+}
+
+// Detects detect target async
+func (detector *UdpDetector) Detects() chan<- DetectTarget[UdpOptions] {
+	return detector.detectBuffer
+}
+
+func (detector *UdpDetector) Results() <-chan DetectResult[UdpOptions, *UdpStatistics] {
+	return detector.resultQueue
 }
@@ -0,0 +1,90 @@
+package detector
+
+import (
+	"net"
+	"testing"
+)
+
+func TestUdpDetector_Detect(t *testing.T) {
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed. %s", err)
+	}
+	defer echo.Close()
+	go func() {
+		var buffer = make([]byte, 1500)
+		for {
+			n, addr, err := echo.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			_, _ = echo.WriteTo(buffer[:n], addr)
+		}
+	}()
+	var openPort = echo.LocalAddr().(*net.UDPAddr).Port
+
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed. %s", err)
+	}
+	defer silent.Close()
+	var filteredPort = silent.LocalAddr().(*net.UDPAddr).Port
+
+	closed, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed. %s", err)
+	}
+	var closedPort = closed.LocalAddr().(*net.UDPAddr).Port
+	_ = closed.Close()
+
+	tests := []struct {
+		name      string
+		options   UdpOptions
+		wantState []DetectStatus
+		wantErr   bool
+	}{
+		{name: "open", options: UdpOptions{Ports: []int{openPort}, Payload: "0a0b"}, wantState: []DetectStatus{UdpPortOpen}},
+		{name: "closed", options: UdpOptions{Ports: []int{closedPort}, Template: "ntp"}, wantState: []DetectStatus{UdpPortClosed}},
+		{name: "filtered", options: UdpOptions{Ports: []int{filteredPort}, Template: "dns"}, wantState: []DetectStatus{UdpPortOpenFiltered}},
+		{name: "invalid payload", options: UdpOptions{Ports: []int{openPort}, Payload: "zz"}, wantErr: true},
+		{name: "unknown template", options: UdpOptions{Ports: []int{openPort}, Template: "foo"}, wantErr: true},
+	}
+	var detector = NewUdpDetector(UdpDetectorOptions{DefaultTimeout: 200, DefaultCount: 2})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var target = NewDetectTarget(UDPDetect, "127.0.0.1", DetectOptions[UdpOptions]{Options: tt.options})
+			got := detector.Detect(target)
+			if (got.Error != nil) != tt.wantErr {
+				t.Errorf("Detect() error = %v, wantErr %v", got.Error, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			for i, port := range got.Result.Ports {
+				if port.State != tt.wantState[i] {
+					t.Errorf("Detect() port %d state = %v, want %v", port.Port, port.State, tt.wantState[i])
+				}
+			}
+		})
+	}
+}
+
+func TestUdpDetector_DetectError(t *testing.T) {
+	var detector = NewUdpDetector(UdpDetectorOptions{DefaultTimeout: 200, DefaultCount: 2})
+	var target = NewDetectTarget(UDPDetect, "nonexistent.invalid", DetectOptions[UdpOptions]{
+		Options: UdpOptions{Ports: []int{53}, Template: "dns"},
+	})
+	got := detector.Detect(target)
+	if got.Error != nil {
+		t.Fatalf("Detect() error = %v", got.Error)
+	}
+	// host can not be resolved, port is not open|filtered
+	var port = got.Result.Ports[0]
+	if port.State != UdpPortError || port.ErrorClass != ErrorResolve || port.Error == "" {
+		t.Errorf("Detect() port = %+v, want error state of resolve", port)
+	}
+	if summary := got.Summary(); summary.Success || summary.ErrorClass != ErrorResolve {
+		t.Errorf("Detect() summary = %+v, want resolve error", summary)
+	}
+}
@@ -0,0 +1,134 @@
+package dispatcher
+
+import (
+	"detect-server/log"
+	"github.com/spf13/viper"
+	"sync"
+)
+
+const (
+	ResultEvent    = "result"
+	CompletedEvent = "completed"
+)
+
+// StreamEvent Data is DefaultMessage of ResultEvent and TaskResult
+// of CompletedEvent
+type StreamEvent struct {
+	Event string `json:"event"`
+	Data  any    `json:"data"`
+}
+
+type BrokerOptions struct {
+	// SubscriberBufferSize events buffered for every subscriber, events
+	// are dropped when subscriber buffer is full
+	SubscriberBufferSize int
+}
+
+func NewBrokerOptions() BrokerOptions {
+	var options = BrokerOptions{
+		SubscriberBufferSize: viper.GetInt("dispatcher.stream.buffer.size"),
+	}
+
+	if options.SubscriberBufferSize <= 0 {
+		options.SubscriberBufferSize = 256
+	}
+	return options
+}
+
+// Broker deliver events of task to its subscribers, publishing never
+// blocks, so slow subscriber can not slow down the dispatcher
+type Broker interface {
+	// Subscribe return events of task, the channel is closed after
+	// CompletedEvent is delivered or cancel is called
+	Subscribe(id string) (events <-chan StreamEvent, cancel func())
+	Publish(id string, event StreamEvent)
+	// Complete deliver CompletedEvent and close all subscribers of task
+	Complete(id string, result TaskResult)
+}
+
+type subscriber struct {
+	events chan StreamEvent
+}
+
+type memoryBroker struct {
+	options     BrokerOptions
+	lock        sync.Mutex
+	subscribers map[string]map[*subscriber]struct{}
+}
+
+func NewBroker(options BrokerOptions) Broker {
+	return &memoryBroker{
+		options:     options,
+		subscribers: make(map[string]map[*subscriber]struct{}),
+	}
+}
+
+func (broker *memoryBroker) Subscribe(id string) (<-chan StreamEvent, func()) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	var sub = &subscriber{events: make(chan StreamEvent, broker.options.SubscriberBufferSize)}
+	if broker.subscribers[id] == nil {
+		broker.subscribers[id] = make(map[*subscriber]struct{})
+	}
+	broker.subscribers[id][sub] = struct{}{}
+
+	var once sync.Once
+	return sub.events, func() {
+		once.Do(func() {
+			broker.unsubscribe(id, sub)
+		})
+	}
+}
+
+func (broker *memoryBroker) unsubscribe(id string, sub *subscriber) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	subs, ok := broker.subscribers[id]
+	if !ok {
+		return
+	}
+	if _, ok = subs[sub]; !ok {
+		return
+	}
+	delete(subs, sub)
+	close(sub.events)
+	if len(subs) == 0 {
+		delete(broker.subscribers, id)
+	}
+}
+
+func (broker *memoryBroker) Publish(id string, event StreamEvent) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	for sub := range broker.subscribers[id] {
+		select {
+		case sub.events <- event:
+		default:
+			log.Logger.Debugf("subscriber of task %s is slow, drop %s event", id, event.Event)
+		}
+	}
+}
+
+func (broker *memoryBroker) Complete(id string, result TaskResult) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	var event = StreamEvent{Event: CompletedEvent, Data: result}
+	for sub := range broker.subscribers[id] {
+		// completed event must be delivered, drop oldest event if full
+		select {
+		case sub.events <- event:
+		default:
+			select {
+			case <-sub.events:
+			default:
+			}
+			sub.events <- event
+		}
+		close(sub.events)
+	}
+	delete(broker.subscribers, id)
+}
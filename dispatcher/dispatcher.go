@@ -6,127 +6,428 @@ import (
 	"detect-server/detector"
 	"detect-server/log"
 	"fmt"
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"sync"
+	"time"
 )
 
-type Dispatcher[T detector.DetectInput, R detector.DetectOutput, F MessageOutput] interface {
+// Dispatcher route targets of task to detector of the target type, and
+// merge results of all detectors to publisher
+type Dispatcher interface {
 	Start() error
 	Stop() error
-	AddReceiver(connector.Receiver[Task[T]])
-	AddDetector(detector.Detector[T, R])
+	AddReceiver(connector.Receiver[Task])
+	AddRoute(route Route)
 	AddPublisher(connector.Publisher[any])
-	AddProcessor(processor Processor[T, R, F])
+	AddTracker(tracker Tracker)
+	AddBroker(broker Broker)
+	AddFilter(filter Filter)
+	AddLimiter(limiter Limiter)
+	// Detect detect targets concurrently by runners of detectors and wait
+	// results until ctx is done, results are in the order of targets
+	Detect(ctx context.Context, targets []detector.Target) []DefaultMessage
+	// Cancel mark task cancelled, targets not dispatched yet or queued in
+	// detectors are skipped and targets being detected are aborted
+	Cancel(id string) (TaskStatus, error)
+	// Drain wait until all tracked tasks are finished, tasks left are
+	// cancelled when ctx is done
+	Drain(ctx context.Context) error
 }
 
-// Task may contain many targets
-type Task[T detector.DetectInput] interface {
+// Task may contain many targets of different protocols, targets are
+// consumed once by dispatcher
+type Task interface {
+	Id() string
 	Name() string
-	Targets() []detector.DetectTarget[T]
+	Priority() Priority
+	Targets() detector.TargetIterator
 }
 
-type task[T detector.DetectInput] struct {
-	name    string
-	targets []detector.DetectTarget[T]
+type task struct {
+	id       string
+	name     string
+	priority Priority
+	targets  detector.TargetIterator
 }
 
-func (t *task[T]) Name() string {
+func (t *task) Id() string {
+	return t.id
+}
+
+func (t *task) Name() string {
 	return t.name
 }
 
-func (t *task[T]) Targets() []detector.DetectTarget[T] {
+func (t *task) Priority() Priority {
+	return t.priority
+}
+
+func (t *task) Targets() detector.TargetIterator {
 	return t.targets
 }
 
-func NewTask[T detector.DetectInput](name string, targets []detector.DetectTarget[T]) Task[T] {
-	return &task[T]{
-		name:    name,
-		targets: targets,
+// NewTask create task with unique id, all targets are bound to the task
+// when they are consumed
+func NewTask(name string, priority Priority, targets detector.TargetIterator) Task {
+	var t = &task{
+		id:       uuid.NewString(),
+		name:     name,
+		priority: priority,
 	}
+	t.targets = detector.MapIterator(targets, func(target detector.Target) detector.Target {
+		return target.WithTask(t.id)
+	})
+	return t
 }
 
+// Options PublishCompleted publish one TaskCompletedMessage when task is
+// completed, per target results are contained if PublishCompletedTargets
 type Options struct {
+	PublishCompleted        bool
+	PublishCompletedTargets bool
+	Priority                PriorityOptions
 }
 
 func NewOptions() Options {
-	return Options{}
+	return Options{
+		PublishCompleted:        viper.GetBool("dispatcher.task.publish.completed"),
+		PublishCompletedTargets: viper.GetBool("dispatcher.task.publish.targets"),
+		Priority:                NewPriorityOptions(),
+	}
 }
 
-type commonDispatcher[T detector.DetectInput, R detector.DetectOutput, F MessageOutput] struct {
+type commonDispatcher struct {
 	options    Options
 	ctx        context.Context
 	cancelFunc context.CancelFunc
-	receiver   connector.Receiver[Task[T]]
-	detector   detector.Detector[T, R]
+	receiver   connector.Receiver[Task]
+	routes     map[detector.DetectType]Route
 	publisher  connector.Publisher[any]
-	processor  Processor[T, R, F]
+	tracker    Tracker
+	broker     Broker
+	filter     Filter
+	limiter    Limiter
+	// cancels cancel context of running tasks, targets of task are bound
+	// to its context
+	cancelsLock sync.Mutex
+	cancels     map[string]context.CancelFunc
 }
 
-func NewDispatcher[T detector.DetectInput, R detector.DetectOutput, F MessageOutput](options Options) Dispatcher[T, R, F] {
-	return &commonDispatcher[T, R, F]{
+func NewDispatcher(options Options) Dispatcher {
+	return &commonDispatcher{
 		options: options,
+		routes:  make(map[detector.DetectType]Route),
+		cancels: make(map[string]context.CancelFunc),
 	}
 }
 
-func (dispatch *commonDispatcher[T, R, F]) AddReceiver(receiver connector.Receiver[Task[T]]) {
+func (dispatch *commonDispatcher) AddReceiver(receiver connector.Receiver[Task]) {
 	dispatch.receiver = receiver
 }
 
-func (dispatch *commonDispatcher[T, R, F]) AddDetector(detector detector.Detector[T, R]) {
-	dispatch.detector = detector
+// AddRoute register route by its detect type, route of same type is replaced
+func (dispatch *commonDispatcher) AddRoute(route Route) {
+	dispatch.routes[route.Type()] = route
 }
 
-func (dispatch *commonDispatcher[T, R, F]) AddPublisher(publisher connector.Publisher[any]) {
+func (dispatch *commonDispatcher) AddPublisher(publisher connector.Publisher[any]) {
 	dispatch.publisher = publisher
 }
 
-func (dispatch *commonDispatcher[T, R, F]) AddProcessor(processor Processor[T, R, F]) {
-	dispatch.processor = processor
+func (dispatch *commonDispatcher) AddTracker(tracker Tracker) {
+	dispatch.tracker = tracker
+}
+
+func (dispatch *commonDispatcher) AddBroker(broker Broker) {
+	dispatch.broker = broker
+}
+
+func (dispatch *commonDispatcher) AddFilter(filter Filter) {
+	dispatch.filter = filter
+}
+
+func (dispatch *commonDispatcher) AddLimiter(limiter Limiter) {
+	dispatch.limiter = limiter
 }
 
-func (dispatch *commonDispatcher[T, R, F]) Start() error {
+func (dispatch *commonDispatcher) Start() error {
 	if dispatch.receiver == nil {
 		return fmt.Errorf("receiver is invalid")
 	}
-	if dispatch.detector == nil {
-		return fmt.Errorf("detector is invalid")
+	if len(dispatch.routes) == 0 {
+		return fmt.Errorf("no detector route is registered")
 	}
 	if dispatch.publisher == nil {
 		return fmt.Errorf("publisher is invalid")
 	}
-	if dispatch.processor == nil {
-		return fmt.Errorf("processor is invalid")
+	if dispatch.tracker == nil {
+		return fmt.Errorf("tracker is invalid")
+	}
+	if dispatch.broker == nil {
+		return fmt.Errorf("broker is invalid")
+	}
+	if dispatch.filter == nil {
+		return fmt.Errorf("filter is invalid")
+	}
+	if dispatch.limiter == nil {
+		return fmt.Errorf("limiter is invalid")
 	}
 	dispatch.ctx, dispatch.cancelFunc = context.WithCancel(context.Background())
+	// tasks of one priority run in order, tasks of different priorities
+	// run concurrently so a large task never blocks task of other priority
+	var lanes = make(map[Priority]*taskQueue, len(priorities))
+	for _, priority := range priorities {
+		lanes[priority] = newTaskQueue()
+		go func(ctx context.Context, priority Priority, lane *taskQueue) {
+			for task, ok := lane.pop(ctx); ok; task, ok = lane.pop(ctx) {
+				dispatch.run(ctx, priority, task)
+			}
+			log.Logger.Infof("stop dispatcher send %s tasks to detector", priority)
+		}(dispatch.ctx, priority, lanes[priority])
+	}
 	go func(ctx context.Context) {
 		for {
 			select {
 			case <-ctx.Done():
-				log.Logger.Infof("stop dispatcher send to detector")
+				log.Logger.Infof("stop dispatcher receive tasks")
 				return
 			case task := <-dispatch.receiver.Receive():
-				for _, target := range task.Targets() {
-					dispatch.detector.Detects() <- target
+				lane, ok := lanes[task.Priority()]
+				if !ok {
+					lane = lanes[PriorityNormal]
 				}
+				lane.push(task)
 			}
 		}
 	}(dispatch.ctx)
 
-	go func(ctx context.Context) {
-		for {
-			select {
-			case <-ctx.Done():
-				log.Logger.Infof("stop dispatcher send to sender")
-				return
-			case result := <-dispatch.detector.Results():
-				log.Logger.Debugf("detect result: %v", result)
-				dispatch.publisher.Publish() <- dispatch.processor.Process(result)
+	for _, route := range dispatch.routes {
+		go func(ctx context.Context, route Route) {
+			route.Schedule(ctx, dispatch.options.Priority.Weights)
+		}(dispatch.ctx, route)
+		go func(ctx context.Context, route Route) {
+			route.Forward(ctx, dispatch.publish)
+			log.Logger.Infof("stop dispatcher send %s result to sender", route.Type())
+		}(dispatch.ctx, route)
+	}
+	return nil
+}
+
+// run dispatch targets of task, targets are created one by one while
+// detectors accept them, targets left are skipped once task is cancelled
+func (dispatch *commonDispatcher) run(ctx context.Context, priority Priority, task Task) {
+	// context is bound before state is checked, so task cancelled at any
+	// time is either skipped here or stopped by its context
+	var taskCtx = dispatch.bind(ctx, task.Id())
+	if dispatch.cancelled(task.Id()) {
+		log.Logger.Infof("skip targets of cancelled task %s", task.Id())
+		dispatch.release(task.Id())
+		return
+	}
+	// task without targets, e.g. all are excluded, is completed at once
+	if dispatch.tracker.Start(task.Id()) {
+		dispatch.complete(task.Id())
+		return
+	}
+	var targets = task.Targets()
+	for target, ok := targets.Next(); ok && taskCtx.Err() == nil; target, ok = targets.Next() {
+		dispatch.dispatch(taskCtx, priority, target.WithContext(taskCtx))
+	}
+}
+
+// bind create context of task, it is cancelled when task is cancelled
+func (dispatch *commonDispatcher) bind(ctx context.Context, id string) context.Context {
+	var taskCtx, cancel = context.WithCancel(ctx)
+	dispatch.cancelsLock.Lock()
+	dispatch.cancels[id] = cancel
+	dispatch.cancelsLock.Unlock()
+	return taskCtx
+}
+
+// release cancel context of task and forget it
+func (dispatch *commonDispatcher) release(id string) {
+	dispatch.cancelsLock.Lock()
+	cancel, ok := dispatch.cancels[id]
+	delete(dispatch.cancels, id)
+	dispatch.cancelsLock.Unlock()
+	if ok {
+		cancel()
+	}
+}
+
+func (dispatch *commonDispatcher) cancelled(id string) bool {
+	status, ok := dispatch.tracker.Get(id)
+	return ok && status.State == TaskCancelled
+}
 
+func (dispatch *commonDispatcher) Cancel(id string) (TaskStatus, error) {
+	status, err := dispatch.tracker.Cancel(id)
+	if err != nil {
+		return status, err
+	}
+	log.Logger.Infof("task %s is cancelled, %d of %d targets dispatched", id, status.Dispatched, status.Total)
+	dispatch.release(id)
+	dispatch.complete(id)
+	return status, nil
+}
+
+func (dispatch *commonDispatcher) Drain(ctx context.Context) error {
+	var ticker = time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	var reported time.Time
+	for {
+		var unfinished = dispatch.tracker.Unfinished()
+		if len(unfinished) == 0 {
+			log.Logger.Infof("all tasks are finished")
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			for _, status := range unfinished {
+				if _, err := dispatch.Cancel(status.Id); err != nil {
+					log.Logger.Debugf("cancel task %s failed. %s", status.Id, err)
+				}
 			}
+			return fmt.Errorf("cancel %d unfinished tasks. %w", len(unfinished), ctx.Err())
+		case <-ticker.C:
 		}
-	}(dispatch.ctx)
-	return nil
+		if time.Since(reported) >= time.Second {
+			var left int
+			for _, status := range unfinished {
+				left += status.Total - status.Completed - status.Filtered
+			}
+			log.Logger.Infof("wait %d tasks finished, %d targets left", len(unfinished), left)
+			reported = time.Now()
+		}
+	}
+}
+
+// dispatch send target to route of its type, target not allowed by
+// filter is dropped, allowed target waits for limiter before it reaches
+// queue of priority in route, target can not be dispatched is published
+// as error message
+func (dispatch *commonDispatcher) dispatch(ctx context.Context, priority Priority, target detector.Target) {
+	checked, err := dispatch.filter.Check(target)
+	if err != nil {
+		log.Logger.Debugf("drop target %s. %s", target.Address(), err)
+		if dispatch.tracker.Filtered(target.TaskId()) {
+			dispatch.complete(target.TaskId())
+		}
+		return
+	}
+	target = checked
+	route, ok := dispatch.routes[target.DetectType()]
+	if ok {
+		if err = dispatch.limiter.Wait(ctx, target); err == nil {
+			err = route.Dispatch(ctx, target, priority)
+		}
+	} else {
+		err = fmt.Errorf("no detector for type %s", target.DetectType())
+	}
+	if err != nil {
+		if dispatch.cancelled(target.TaskId()) {
+			return
+		}
+		log.Logger.Warnf("dispatch target %s failed. %s", target.Address(), err)
+		dispatch.publish(errorMessage(target, err))
+		return
+	}
+	dispatch.tracker.Dispatched(target.TaskId())
+}
+
+// publish record progress of task and send message to publisher and
+// stream subscribers
+func (dispatch *commonDispatcher) publish(message DefaultMessage) {
+	if dispatch.cancelled(message.TaskId) {
+		log.Logger.Debugf("drop result of cancelled task: %v", message)
+		return
+	}
+	log.Logger.Debugf("detect result: %v", message)
+	var finished = dispatch.tracker.Done(message)
+	dispatch.publisher.Publish() <- message
+	dispatch.broker.Publish(message.TaskId, StreamEvent{Event: ResultEvent, Data: message})
+	if finished {
+		dispatch.complete(message.TaskId)
+	}
+}
+
+// complete notify stream subscribers and publish aggregated result of
+// completed or cancelled task if enabled
+func (dispatch *commonDispatcher) complete(id string) {
+	log.Logger.Debugf("task %s completed", id)
+	dispatch.release(id)
+	result, ok := dispatch.tracker.Result(id, false)
+	if !ok {
+		return
+	}
+	dispatch.broker.Complete(id, result)
+	if !dispatch.options.PublishCompleted {
+		return
+	}
+	if dispatch.options.PublishCompletedTargets {
+		result, _ = dispatch.tracker.Result(id, true)
+	}
+	dispatch.publisher.Publish() <- TaskCompletedMessage{
+		Event:  TaskCompletedEvent,
+		Result: result,
+	}
+}
+
+func (dispatch *commonDispatcher) Detect(ctx context.Context, targets []detector.Target) []DefaultMessage {
+	type indexedMessage struct {
+		index   int
+		message DefaultMessage
+	}
+	var messages = make([]DefaultMessage, len(targets))
+	var done = make([]bool, len(targets))
+	var results = make(chan indexedMessage, len(targets))
+	for i, target := range targets {
+		go func(index int, target detector.Target) {
+			results <- indexedMessage{index: index, message: dispatch.detect(ctx, target)}
+		}(i, target)
+	}
+
+	for received := 0; received < len(targets); received++ {
+		select {
+		case <-ctx.Done():
+			for i, target := range targets {
+				if !done[i] {
+					messages[i] = errorMessage(target, ctx.Err())
+				}
+			}
+			return messages
+		case result := <-results:
+			messages[result.index] = result.message
+			done[result.index] = true
+		}
+	}
+	return messages
+}
+
+// detect detect target by route of its type sync, probes of target stop
+// when ctx is done
+func (dispatch *commonDispatcher) detect(ctx context.Context, target detector.Target) DefaultMessage {
+	checked, err := dispatch.filter.Check(target)
+	if err != nil {
+		return errorMessage(target, err)
+	}
+	target = checked
+	route, ok := dispatch.routes[target.DetectType()]
+	if !ok {
+		return errorMessage(target, fmt.Errorf("no detector for type %s", target.DetectType()))
+	}
+	if err := dispatch.limiter.Wait(ctx, target); err != nil {
+		return errorMessage(target, err)
+	}
+	message, err := route.Detect(ctx, target)
+	if err != nil {
+		return errorMessage(target, err)
+	}
+	return message
 }
 
-func (dispatch *commonDispatcher[T, R, F]) Stop() error {
+func (dispatch *commonDispatcher) Stop() error {
 	if dispatch.cancelFunc != nil {
 		dispatch.cancelFunc()
 	}
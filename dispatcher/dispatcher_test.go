@@ -0,0 +1,481 @@
+package dispatcher
+
+import (
+	"context"
+	"detect-server/connector"
+	"detect-server/detector"
+	"detect-server/log"
+	"errors"
+	"go.uber.org/zap"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	log.Logger = zap.NewNop().Sugar()
+}
+
+// echoDetector return empty result for every target, or result of
+// detect if it is set
+type echoDetector[T detector.DetectInput, R detector.DetectOutput] struct {
+	targets chan detector.DetectTarget[T]
+	results chan detector.DetectResult[T, R]
+	stop    chan struct{}
+	detect  func(target detector.DetectTarget[T]) detector.DetectResult[T, R]
+}
+
+func newEchoDetector[T detector.DetectInput, R detector.DetectOutput]() *echoDetector[T, R] {
+	return &echoDetector[T, R]{
+		targets: make(chan detector.DetectTarget[T], 10),
+		results: make(chan detector.DetectResult[T, R], 10),
+		stop:    make(chan struct{}),
+	}
+}
+
+func (d *echoDetector[T, R]) Start() error {
+	go func() {
+		for {
+			select {
+			case <-d.stop:
+				return
+			case target := <-d.targets:
+				d.results <- d.Detect(target)
+			}
+		}
+	}()
+	return nil
+}
+
+func (d *echoDetector[T, R]) Stop() error {
+	close(d.stop)
+	return nil
+}
+
+func (d *echoDetector[T, R]) Detect(target detector.DetectTarget[T]) detector.DetectResult[T, R] {
+	if d.detect != nil {
+		return d.detect(target)
+	}
+	var result R
+	return detector.NewDetectResult(target, result, nil)
+}
+
+func (d *echoDetector[T, R]) Detects() chan<- detector.DetectTarget[T] {
+	return d.targets
+}
+
+func (d *echoDetector[T, R]) Results() <-chan detector.DetectResult[T, R] {
+	return d.results
+}
+
+func TestCommonDispatcher_Route(t *testing.T) {
+	var (
+		icmpDetector = newEchoDetector[detector.IcmpOptions, *detector.IcmpStatistics]()
+		tcpDetector  = newEchoDetector[detector.TcpOptions, *detector.TcpStatistics]()
+		receiver     = connector.NewChanConnector[Task](connector.Options{MaxBufferSize: 10})
+		publisher    = connector.NewChanConnector[any](connector.Options{MaxBufferSize: 10})
+		dispatch     = NewDispatcher(NewOptions())
+		tracker      = NewTracker(TrackerOptions{Retention: time.Minute})
+		broker       = NewBroker(BrokerOptions{SubscriberBufferSize: 10})
+		filter, _    = NewFilter(FilterOptions{Deny: []string{"10.0.0.4"}})
+	)
+	_ = icmpDetector.Start()
+	_ = tcpDetector.Start()
+	defer icmpDetector.Stop()
+	defer tcpDetector.Stop()
+
+	dispatch.AddReceiver(receiver)
+	dispatch.AddRoute(NewRoute[detector.IcmpOptions, *detector.IcmpStatistics](detector.ICMPDetect, icmpDetector,
+		NewDefaultProcessor[detector.IcmpOptions, *detector.IcmpStatistics, DefaultMessage]()))
+	dispatch.AddRoute(NewRoute[detector.TcpOptions, *detector.TcpStatistics](detector.TCPDetect, tcpDetector,
+		NewDefaultProcessor[detector.TcpOptions, *detector.TcpStatistics, DefaultMessage]()))
+	dispatch.AddPublisher(publisher)
+	dispatch.AddTracker(tracker)
+	dispatch.AddBroker(broker)
+	dispatch.AddFilter(filter)
+	dispatch.AddLimiter(NewLimiter(LimiterOptions{}))
+	if err := dispatch.Start(); err != nil {
+		t.Fatalf("start dispatcher failed. %s", err)
+	}
+	defer dispatch.Stop()
+
+	var task = NewTask("mixed", PriorityNormal, detector.NewSliceIterator(
+		detector.NewDetectTarget(detector.ICMPDetect, "10.0.0.1", detector.DetectOptions[detector.IcmpOptions]{}),
+		detector.NewDetectTarget(detector.TCPDetect, "10.0.0.2", detector.DetectOptions[detector.TcpOptions]{}),
+		detector.NewDetectTarget(detector.UDPDetect, "10.0.0.3", detector.DetectOptions[detector.UdpOptions]{}),
+		detector.NewDetectTarget(detector.ICMPDetect, "10.0.0.4", detector.DetectOptions[detector.IcmpOptions]{}),
+	))
+	if status := tracker.Track(task); status.State != TaskPending || status.Total != 4 {
+		t.Fatalf("tracked task status = %+v, want pending with 4 targets", status)
+	}
+	events, cancel := broker.Subscribe(task.Id())
+	defer cancel()
+	receiver.Publish() <- task
+
+	var want = map[string]detector.DetectType{
+		"10.0.0.1": detector.ICMPDetect,
+		"10.0.0.2": detector.TCPDetect,
+		"10.0.0.3": detector.UDPDetect,
+	}
+	for i := 0; i < len(want); i++ {
+		select {
+		case msg := <-publisher.Receive():
+			var message = msg.(DefaultMessage)
+			if want[message.Target] != message.Type {
+				t.Errorf("message of %s type = %v, want %v", message.Target, message.Type, want[message.Target])
+			}
+			if message.TaskId != task.Id() {
+				t.Errorf("message of %s task = %v, want %v", message.Target, message.TaskId, task.Id())
+			}
+			if message.Type == detector.UDPDetect && message.Error == nil {
+				t.Errorf("target without detector should be published with error")
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("wait message timeout")
+		}
+	}
+
+	status, _ := tracker.Get(task.Id())
+	if status.State != TaskCompleted || status.Completed != 3 || status.Dispatched != 2 || status.Failed != 3 ||
+		status.Filtered != 1 {
+		t.Errorf("task status = %+v, want completed", status)
+	}
+	result, _ := tracker.Result(task.Id(), true)
+	if result.Total != 4 || result.Alive != 0 || result.Dead != 2 || result.Errors != 1 || result.Filtered != 1 ||
+		len(result.Targets) != 3 {
+		t.Errorf("task result = %+v, want 2 dead, 1 error and 1 filtered", result)
+	}
+
+	var streamed []string
+	for event := range events {
+		streamed = append(streamed, event.Event)
+	}
+	if len(streamed) != 4 || streamed[3] != CompletedEvent {
+		t.Errorf("streamed events = %v, want 3 results and completed", streamed)
+	}
+}
+
+func TestCommonDispatcher_Cancel(t *testing.T) {
+	var (
+		icmpDetector = newEchoDetector[detector.IcmpOptions, *detector.IcmpStatistics]()
+		detected     = make(chan string, 100)
+		receiver     = connector.NewChanConnector[Task](connector.Options{MaxBufferSize: 10})
+		publisher    = connector.NewChanConnector[any](connector.Options{MaxBufferSize: 10})
+		dispatch     = NewDispatcher(NewOptions())
+		tracker      = NewTracker(TrackerOptions{Retention: time.Minute})
+		broker       = NewBroker(BrokerOptions{SubscriberBufferSize: 10})
+		filter, _    = NewFilter(FilterOptions{})
+	)
+	// targets of 10.0.1.0/24 are detected until task is cancelled
+	icmpDetector.targets = make(chan detector.DetectTarget[detector.IcmpOptions], 1)
+	icmpDetector.detect = func(target detector.DetectTarget[detector.IcmpOptions]) detector.DetectResult[detector.IcmpOptions, *detector.IcmpStatistics] {
+		detected <- target.Target
+		if strings.HasPrefix(target.Target, "10.0.1.") {
+			<-target.Context().Done()
+			return detector.NewDetectResult[detector.IcmpOptions, *detector.IcmpStatistics](target, nil, target.Context().Err())
+		}
+		return detector.NewDetectResult[detector.IcmpOptions, *detector.IcmpStatistics](target, nil, nil)
+	}
+	_ = icmpDetector.Start()
+	defer icmpDetector.Stop()
+	dispatch.AddReceiver(receiver)
+	dispatch.AddRoute(NewRoute[detector.IcmpOptions, *detector.IcmpStatistics](detector.ICMPDetect, icmpDetector,
+		NewDefaultProcessor[detector.IcmpOptions, *detector.IcmpStatistics, DefaultMessage]()))
+	dispatch.AddPublisher(publisher)
+	dispatch.AddTracker(tracker)
+	dispatch.AddBroker(broker)
+	dispatch.AddFilter(filter)
+	dispatch.AddLimiter(NewLimiter(LimiterOptions{}))
+	if err := dispatch.Start(); err != nil {
+		t.Fatalf("start dispatcher failed. %s", err)
+	}
+	defer dispatch.Stop()
+
+	var newTask = func(targets ...string) Task {
+		var detects = make([]detector.Target, 0, len(targets))
+		for _, target := range targets {
+			detects = append(detects, detector.NewDetectTarget(detector.ICMPDetect, target, detector.DetectOptions[detector.IcmpOptions]{}))
+		}
+		var task = NewTask("cancel", PriorityNormal, detector.NewSliceIterator(detects...))
+		tracker.Track(task)
+		return task
+	}
+	var (
+		running = newTask("10.0.1.1", "10.0.1.2", "10.0.1.3", "10.0.1.4", "10.0.1.5")
+		queued  = newTask("10.0.2.1")
+		next    = newTask("10.0.3.1")
+	)
+	events, cancel := broker.Subscribe(running.Id())
+	defer cancel()
+	receiver.Publish() <- running
+	receiver.Publish() <- queued
+	receiver.Publish() <- next
+
+	// first target is being detected, second is queued in detector, third
+	// is queued in route and fourth waits for route
+	select {
+	case <-detected:
+	case <-time.After(time.Second):
+		t.Fatalf("wait target detected timeout")
+	}
+	if _, err := dispatch.Cancel(queued.Id()); err != nil {
+		t.Fatalf("Cancel() queued task error = %v", err)
+	}
+	status, err := dispatch.Cancel(running.Id())
+	if err != nil || status.State != TaskCancelled {
+		t.Fatalf("Cancel() = %+v, %v, want cancelled", status, err)
+	}
+	if _, err = dispatch.Cancel(running.Id()); !errors.Is(err, ErrTaskFinished) {
+		t.Errorf("Cancel() cancelled task error = %v, want %v", err, ErrTaskFinished)
+	}
+	if _, err = dispatch.Cancel("unknown"); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("Cancel() unknown task error = %v, want %v", err, ErrTaskNotFound)
+	}
+
+	// targets of cancelled task left in detector and route return at once,
+	// targets not dispatched yet and queued task are skipped
+	var targets []string
+	for len(targets) == 0 || targets[len(targets)-1] != "10.0.3.1" {
+		select {
+		case target := <-detected:
+			targets = append(targets, target)
+		case <-time.After(time.Second):
+			t.Fatalf("wait target of next task timeout, detected %v", targets)
+		}
+	}
+	for _, target := range targets {
+		if target == "10.0.1.5" || target == "10.0.2.1" {
+			t.Errorf("target %s of cancelled task is detected", target)
+		}
+	}
+	select {
+	case msg := <-publisher.Receive():
+		if message := msg.(DefaultMessage); message.TaskId != next.Id() {
+			t.Errorf("result of cancelled task %s is published", message.Target)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("wait result of next task timeout")
+	}
+
+	status, _ = tracker.Get(running.Id())
+	if status.State != TaskCancelled || status.Completed != 0 || status.Dispatched > 4 {
+		t.Errorf("cancelled task status = %+v, want cancelled without results", status)
+	}
+	if status, _ = tracker.Get(queued.Id()); status.State != TaskCancelled || status.Dispatched != 0 {
+		t.Errorf("queued task status = %+v, want cancelled without dispatched targets", status)
+	}
+	var streamed []StreamEvent
+	for event := range events {
+		streamed = append(streamed, event)
+	}
+	if len(streamed) != 1 || streamed[0].Data.(TaskResult).State != TaskCancelled {
+		t.Errorf("streamed events = %+v, want cancelled result", streamed)
+	}
+}
+
+func TestCommonDispatcher_Drain(t *testing.T) {
+	var (
+		icmpDetector = newEchoDetector[detector.IcmpOptions, *detector.IcmpStatistics]()
+		receiver     = connector.NewChanConnector[Task](connector.Options{MaxBufferSize: 10})
+		publisher    = connector.NewChanConnector[any](connector.Options{MaxBufferSize: 10})
+		dispatch     = NewDispatcher(NewOptions())
+		tracker      = NewTracker(TrackerOptions{Retention: time.Minute})
+		broker       = NewBroker(BrokerOptions{SubscriberBufferSize: 10})
+		filter, _    = NewFilter(FilterOptions{})
+	)
+	// targets of 10.0.1.0/24 are detected until task is cancelled
+	icmpDetector.detect = func(target detector.DetectTarget[detector.IcmpOptions]) detector.DetectResult[detector.IcmpOptions, *detector.IcmpStatistics] {
+		if strings.HasPrefix(target.Target, "10.0.1.") {
+			<-target.Context().Done()
+			return detector.NewDetectResult[detector.IcmpOptions, *detector.IcmpStatistics](target, nil, target.Context().Err())
+		}
+		return detector.NewDetectResult[detector.IcmpOptions, *detector.IcmpStatistics](target, nil, nil)
+	}
+	_ = icmpDetector.Start()
+	defer icmpDetector.Stop()
+	dispatch.AddReceiver(receiver)
+	dispatch.AddRoute(NewRoute[detector.IcmpOptions, *detector.IcmpStatistics](detector.ICMPDetect, icmpDetector,
+		NewDefaultProcessor[detector.IcmpOptions, *detector.IcmpStatistics, DefaultMessage]()))
+	dispatch.AddPublisher(publisher)
+	dispatch.AddTracker(tracker)
+	dispatch.AddBroker(broker)
+	dispatch.AddFilter(filter)
+	dispatch.AddLimiter(NewLimiter(LimiterOptions{}))
+	if err := dispatch.Start(); err != nil {
+		t.Fatalf("start dispatcher failed. %s", err)
+	}
+	defer dispatch.Stop()
+
+	var submit = func(priority Priority, targets ...string) Task {
+		var detects = make([]detector.Target, 0, len(targets))
+		for _, target := range targets {
+			detects = append(detects, detector.NewDetectTarget(detector.ICMPDetect, target, detector.DetectOptions[detector.IcmpOptions]{}))
+		}
+		var task = NewTask("drain", priority, detector.NewSliceIterator(detects...))
+		tracker.Track(task)
+		receiver.Publish() <- task
+		return task
+	}
+
+	// tasks received are finished before drain returns
+	var quick = submit(PriorityNormal, "10.0.2.1", "10.0.2.2", "10.0.2.3")
+	if err := dispatch.Drain(context.Background()); err != nil {
+		t.Fatalf("Drain() error = %v", err)
+	}
+	if status, _ := tracker.Get(quick.Id()); status.State != TaskCompleted || status.Completed != 3 {
+		t.Errorf("drained task status = %+v, want completed", status)
+	}
+
+	// tasks left when ctx is done are cancelled
+	var slow = submit(PriorityBulk, "10.0.1.1")
+	var ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := dispatch.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Drain() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if status, _ := tracker.Get(slow.Id()); status.State != TaskCancelled {
+		t.Errorf("undrained task status = %+v, want cancelled", status)
+	}
+	if unfinished := tracker.Unfinished(); len(unfinished) != 0 {
+		t.Errorf("Unfinished() = %+v, want none", unfinished)
+	}
+}
+
+func TestCommonDispatcher_EmptyTask(t *testing.T) {
+	var (
+		icmpDetector = newEchoDetector[detector.IcmpOptions, *detector.IcmpStatistics]()
+		receiver     = connector.NewChanConnector[Task](connector.Options{MaxBufferSize: 10})
+		publisher    = connector.NewChanConnector[any](connector.Options{MaxBufferSize: 10})
+		dispatch     = NewDispatcher(Options{PublishCompleted: true})
+		tracker      = NewTracker(TrackerOptions{Retention: time.Minute})
+		broker       = NewBroker(BrokerOptions{SubscriberBufferSize: 10})
+		filter, _    = NewFilter(FilterOptions{})
+	)
+	_ = icmpDetector.Start()
+	defer icmpDetector.Stop()
+	dispatch.AddReceiver(receiver)
+	dispatch.AddRoute(NewRoute[detector.IcmpOptions, *detector.IcmpStatistics](detector.ICMPDetect, icmpDetector,
+		NewDefaultProcessor[detector.IcmpOptions, *detector.IcmpStatistics, DefaultMessage]()))
+	dispatch.AddPublisher(publisher)
+	dispatch.AddTracker(tracker)
+	dispatch.AddBroker(broker)
+	dispatch.AddFilter(filter)
+	dispatch.AddLimiter(NewLimiter(LimiterOptions{}))
+	if err := dispatch.Start(); err != nil {
+		t.Fatalf("start dispatcher failed. %s", err)
+	}
+	defer dispatch.Stop()
+
+	// all targets are excluded
+	var task = NewTask("empty", PriorityNormal, detector.NewSliceIterator())
+	tracker.Track(task)
+	events, cancel := broker.Subscribe(task.Id())
+	defer cancel()
+	receiver.Publish() <- task
+
+	select {
+	case event := <-events:
+		if event.Event != CompletedEvent || event.Data.(TaskResult).State != TaskCompleted {
+			t.Errorf("streamed event = %+v, want completed result", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("wait completed event timeout")
+	}
+	select {
+	case msg := <-publisher.Receive():
+		if message, ok := msg.(TaskCompletedMessage); !ok || message.Result.TaskId != task.Id() {
+			t.Errorf("published message = %+v, want completed message of task", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("wait completed message timeout")
+	}
+	var common = dispatch.(*commonDispatcher)
+	common.cancelsLock.Lock()
+	defer common.cancelsLock.Unlock()
+	if len(common.cancels) != 0 {
+		t.Errorf("context of completed task is not released")
+	}
+}
+
+func TestCommonDispatcher_Detect(t *testing.T) {
+	var (
+		icmpDetector = newEchoDetector[detector.IcmpOptions, *detector.IcmpStatistics]()
+		aborted      = make(chan error, 1)
+		receiver     = connector.NewChanConnector[Task](connector.Options{MaxBufferSize: 10})
+		publisher    = connector.NewChanConnector[any](connector.Options{MaxBufferSize: 10})
+		dispatch     = NewDispatcher(NewOptions())
+		tracker      = NewTracker(TrackerOptions{Retention: time.Minute})
+		broker       = NewBroker(BrokerOptions{SubscriberBufferSize: 10})
+		filter, _    = NewFilter(FilterOptions{})
+	)
+	// 10.0.0.2 is detected until its context is done
+	icmpDetector.detect = func(target detector.DetectTarget[detector.IcmpOptions]) detector.DetectResult[detector.IcmpOptions, *detector.IcmpStatistics] {
+		if target.Target == "10.0.0.2" {
+			<-target.Context().Done()
+			aborted <- target.Context().Err()
+			return detector.NewDetectResult[detector.IcmpOptions, *detector.IcmpStatistics](target, nil, target.Context().Err())
+		}
+		return detector.NewDetectResult[detector.IcmpOptions, *detector.IcmpStatistics](target, &detector.IcmpStatistics{}, nil)
+	}
+	_ = icmpDetector.Start()
+	defer icmpDetector.Stop()
+	dispatch.AddReceiver(receiver)
+	dispatch.AddRoute(NewRoute[detector.IcmpOptions, *detector.IcmpStatistics](detector.ICMPDetect, icmpDetector,
+		NewDefaultProcessor[detector.IcmpOptions, *detector.IcmpStatistics, DefaultMessage]()))
+	dispatch.AddPublisher(publisher)
+	dispatch.AddTracker(tracker)
+	dispatch.AddBroker(broker)
+	dispatch.AddFilter(filter)
+	dispatch.AddLimiter(NewLimiter(LimiterOptions{}))
+	if err := dispatch.Start(); err != nil {
+		t.Fatalf("start dispatcher failed. %s", err)
+	}
+	defer dispatch.Stop()
+
+	var newTarget = func(address string) detector.Target {
+		return detector.NewDetectTarget(detector.ICMPDetect, address, detector.DetectOptions[detector.IcmpOptions]{})
+	}
+	tests := []struct {
+		name        string
+		targets     []string
+		timeout     time.Duration
+		wantErrs    []bool
+		wantAborted bool
+	}{
+		{name: "success", targets: []string{"10.0.0.1", "10.0.0.3"}, timeout: time.Second, wantErrs: []bool{false, false}},
+		{name: "timeout", targets: []string{"10.0.0.2"}, timeout: 100 * time.Millisecond,
+			wantErrs: []bool{true}, wantAborted: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var targets = make([]detector.Target, 0, len(tt.targets))
+			for _, address := range tt.targets {
+				targets = append(targets, newTarget(address))
+			}
+			var ctx, cancel = context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			var messages = dispatch.Detect(ctx, targets)
+			for i, message := range messages {
+				if message.Target != tt.targets[i] || (message.Error != nil) != tt.wantErrs[i] || message.TaskId != "" {
+					t.Errorf("Detect() message %d = %+v, want target %s with error %v", i, message, tt.targets[i], tt.wantErrs[i])
+				}
+			}
+			if tt.wantAborted {
+				select {
+				case err := <-aborted:
+					if !errors.Is(err, context.DeadlineExceeded) {
+						t.Errorf("probe aborted by %v, want %v", err, context.DeadlineExceeded)
+					}
+				case <-time.After(time.Second):
+					t.Errorf("probe is not aborted after timeout")
+				}
+			}
+		})
+	}
+	// results of sync targets are returned to caller only
+	select {
+	case msg := <-publisher.Receive():
+		t.Errorf("result of sync target is published: %+v", msg)
+	default:
+	}
+}
@@ -0,0 +1,154 @@
+package dispatcher
+
+import (
+	"context"
+	"detect-server/detector"
+	"fmt"
+	"github.com/spf13/viper"
+	"github.com/yl2chen/cidranger"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// FilterOptions networks of targets can be detected, targets must be in
+// Allow networks if Allow is not empty, and never in Deny networks
+type FilterOptions struct {
+	Allow []string
+	Deny  []string
+	// ResolveTimeout timeout of resolving hostname target to check it
+	ResolveTimeout time.Duration
+}
+
+func NewFilterOptions() FilterOptions {
+	var options = FilterOptions{
+		Allow:          viper.GetStringSlice("filter.allow"),
+		Deny:           viper.GetStringSlice("filter.deny"),
+		ResolveTimeout: time.Duration(viper.GetInt("filter.resolve.timeout")) * time.Millisecond,
+	}
+
+	if options.ResolveTimeout <= 0 {
+		options.ResolveTimeout = 3 * time.Second
+	}
+	return options
+}
+
+// Filter check every target before it is dispatched to detector
+type Filter interface {
+	// Check return target pinned to ips checked, or error wraps
+	// detector.ErrTargetFiltered if target is not allowed
+	Check(target detector.Target) (detector.Target, error)
+}
+
+type cidrFilter struct {
+	options FilterOptions
+	allow   cidranger.Ranger
+	deny    cidranger.Ranger
+}
+
+func NewFilter(options FilterOptions) (Filter, error) {
+	// resolving with zero timeout always fails, which denies every hostname
+	if options.ResolveTimeout <= 0 {
+		options.ResolveTimeout = 3 * time.Second
+	}
+	var filter = &cidrFilter{options: options}
+	var err error
+	if len(options.Allow) > 0 {
+		if filter.allow, err = newRanger(options.Allow); err != nil {
+			return nil, fmt.Errorf("invalid allow network. %s", err)
+		}
+	}
+	if len(options.Deny) > 0 {
+		if filter.deny, err = newRanger(options.Deny); err != nil {
+			return nil, fmt.Errorf("invalid deny network. %s", err)
+		}
+	}
+	return filter, nil
+}
+
+// newRanger parse networks, single ip is taken as host network
+func newRanger(networks []string) (cidranger.Ranger, error) {
+	var ranger = cidranger.NewPCTrieRanger()
+	for _, network := range networks {
+		if !strings.Contains(network, "/") {
+			if ip := net.ParseIP(network); ip != nil && ip.To4() != nil {
+				network += "/32"
+			} else {
+				network += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			return nil, err
+		}
+		if err = ranger.Insert(cidranger.NewBasicRangerEntry(*ipNet)); err != nil {
+			return nil, err
+		}
+	}
+	return ranger, nil
+}
+
+// Check resolve hostname target and check all its ips, so hostname can
+// not be used to bypass the filter. target is pinned to the checked ips so
+// detector does not resolve it again, and denied if it can not be resolved.
+// ip dialed by detector besides the target, e.g. location of http redirect,
+// is checked by the filter too
+func (filter *cidrFilter) Check(target detector.Target) (detector.Target, error) {
+	if filter.allow == nil && filter.deny == nil {
+		return target, nil
+	}
+	var host = targetHost(target)
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = append(ips, ip)
+	} else {
+		var ctx, cancel = context.WithTimeout(context.Background(), filter.options.ResolveTimeout)
+		defer cancel()
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, fmt.Errorf("%w, resolve %s failed. %s", detector.ErrTargetFiltered, host, err)
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+		target = target.WithResolved(ips)
+	}
+
+	for _, ip := range ips {
+		if err := filter.checkIP(ip); err != nil {
+			return nil, err
+		}
+	}
+	return target.WithDialCheck(filter.checkIP), nil
+}
+
+// checkIP return error wraps detector.ErrTargetFiltered if ip is not allowed
+func (filter *cidrFilter) checkIP(ip net.IP) error {
+	if filter.deny != nil {
+		if denied, _ := filter.deny.Contains(ip); denied {
+			return fmt.Errorf("%w, %s is in deny networks", detector.ErrTargetFiltered, ip)
+		}
+	}
+	if filter.allow != nil {
+		if allowed, _ := filter.allow.Contains(ip); !allowed {
+			return fmt.Errorf("%w, %s is not in allow networks", detector.ErrTargetFiltered, ip)
+		}
+	}
+	return nil
+}
+
+// targetHost return host of target, host of url is parsed for http target
+// whose url defaults to http scheme
+func targetHost(target detector.Target) string {
+	var host = target.Address()
+	if target.DetectType() == detector.HTTPDetect && !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	if strings.Contains(host, "://") {
+		if u, err := url.Parse(host); err == nil {
+			host = u.Hostname()
+		}
+	}
+	return host
+}
@@ -0,0 +1,112 @@
+package dispatcher
+
+import (
+	"detect-server/detector"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCidrFilter_Check(t *testing.T) {
+	filter, err := NewFilter(FilterOptions{
+		Allow: []string{"127.0.0.0/8", "192.0.2.0/24", "2001:db8::/32"},
+		Deny:  []string{"192.0.2.128/25", "127.0.0.2"},
+	})
+	if err != nil {
+		t.Fatalf("NewFilter() error = %v", err)
+	}
+	tests := []struct {
+		name    string
+		target  string
+		http    bool
+		allowed bool
+	}{
+		{name: "allowed", target: "192.0.2.1", allowed: true},
+		{name: "denied network", target: "192.0.2.200", allowed: false},
+		{name: "denied host", target: "127.0.0.2", allowed: false},
+		{name: "not in allow", target: "198.51.100.1", allowed: false},
+		{name: "ipv6", target: "2001:db8::1", allowed: true},
+		{name: "hostname", target: "localhost", allowed: true},
+		{name: "url", target: "http://192.0.2.130:8080/health", allowed: false},
+		{name: "unresolvable", target: "unknown.invalid", allowed: false},
+		{name: "url without scheme", target: "192.0.2.1:8080/health", http: true, allowed: true},
+		{name: "hostname url without scheme", target: "localhost:8080/health", http: true, allowed: true},
+		{name: "denied url without scheme", target: "192.0.2.130/health", http: true, allowed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var target detector.Target = detector.NewDetectTarget(detector.ICMPDetect, tt.target, detector.DetectOptions[detector.IcmpOptions]{})
+			if tt.http {
+				target = detector.NewDetectTarget(detector.HTTPDetect, tt.target, detector.DetectOptions[detector.HttpOptions]{})
+			}
+			checked, err := filter.Check(target)
+			if (err == nil) != tt.allowed || (checked != nil) != tt.allowed {
+				t.Errorf("Check() error = %v, allowed %v", err, tt.allowed)
+			}
+			if err != nil && !errors.Is(err, detector.ErrTargetFiltered) {
+				t.Errorf("Check() error = %v, want ErrTargetFiltered", err)
+			}
+		})
+	}
+
+	// nothing is checked without networks
+	filter, _ = NewFilter(FilterOptions{})
+	var target = detector.NewDetectTarget(detector.ICMPDetect, "unknown.invalid", detector.DetectOptions[detector.IcmpOptions]{})
+	if checked, err := filter.Check(target); err != nil || checked == nil || checked.Address() != target.Address() {
+		t.Errorf("Check() without networks = %v, %v, want target allowed", checked, err)
+	}
+
+	if _, err = NewFilter(FilterOptions{Deny: []string{"10.0.0.0/33"}}); err == nil {
+		t.Errorf("NewFilter() error = nil, want invalid network")
+	}
+}
+
+func TestCidrFilter_CheckRedirect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skipf("listen 127.0.0.2 failed. %s", err)
+	}
+	var internal = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("internal admin page"))
+	}))
+	internal.Listener = listener
+	internal.Start()
+	defer internal.Close()
+	var public = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, internal.URL+"/admin", http.StatusFound)
+	}))
+	defer public.Close()
+
+	_, port, _ := net.SplitHostPort(public.Listener.Addr().String())
+
+	filter, err := NewFilter(FilterOptions{Deny: []string{"127.0.0.2"}})
+	if err != nil {
+		t.Fatalf("NewFilter() error = %v", err)
+	}
+	var httpDetector = detector.NewHttpDetector(detector.HttpDetectorOptions{DefaultTimeout: 1000, DefaultCount: 1})
+	tests := []struct {
+		name    string
+		address string
+	}{
+		{name: "redirect into denied network", address: public.URL},
+		{name: "hostname redirect into denied network", address: "http://localhost:" + port},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var target = detector.NewDetectTarget(detector.HTTPDetect, tt.address, detector.DetectOptions[detector.HttpOptions]{
+				Options: detector.HttpOptions{FollowRedirects: true, BodyContains: "admin"},
+			})
+			checked, err := filter.Check(target)
+			if err != nil {
+				t.Fatalf("Check() error = %v, want allowed", err)
+			}
+			var got = httpDetector.Detect(checked.(detector.DetectTarget[detector.HttpOptions]))
+			var attempt = got.Result.Attempts[0]
+			if attempt.Success || attempt.ErrorClass != detector.ErrorFiltered {
+				t.Errorf("Detect() attempt = %+v, want redirect filtered", attempt)
+			}
+		})
+	}
+}
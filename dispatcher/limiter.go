@@ -0,0 +1,164 @@
+package dispatcher
+
+import (
+	"context"
+	"detect-server/detector"
+	"github.com/spf13/viper"
+	"golang.org/x/time/rate"
+	"net"
+	"sync"
+	"time"
+)
+
+// limiterIdleTimeout limiter of subnet or destination not used for this
+// long is removed
+const limiterIdleTimeout = time.Minute
+
+// LimiterOptions probes per second and burst of all targets, targets in
+// same /24 (/64 of ipv6) and same destination, limit <= 0 means no limit
+type LimiterOptions struct {
+	GlobalLimit      int
+	GlobalBurst      int
+	SubnetLimit      int
+	SubnetBurst      int
+	DestinationLimit int
+	DestinationBurst int
+}
+
+func NewLimiterOptions() LimiterOptions {
+	return LimiterOptions{
+		GlobalLimit:      viper.GetInt("dispatcher.rate.global.limit"),
+		GlobalBurst:      viper.GetInt("dispatcher.rate.global.burst"),
+		SubnetLimit:      viper.GetInt("dispatcher.rate.subnet.limit"),
+		SubnetBurst:      viper.GetInt("dispatcher.rate.subnet.burst"),
+		DestinationLimit: viper.GetInt("dispatcher.rate.destination.limit"),
+		DestinationBurst: viper.GetInt("dispatcher.rate.destination.burst"),
+	}
+}
+
+// Limiter pace targets before they are sent to detector
+type Limiter interface {
+	// Wait block until probes of target are allowed by all limits or ctx
+	// is done
+	Wait(ctx context.Context, target detector.Target) error
+}
+
+type keyedLimiter struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+// limiterGroup token buckets created by key on demand
+type limiterGroup struct {
+	limit     rate.Limit
+	burst     int
+	limiters  map[string]*keyedLimiter
+	lastSweep time.Time
+}
+
+type tokenLimiter struct {
+	lock         sync.Mutex
+	global       *rate.Limiter
+	subnets      *limiterGroup
+	destinations *limiterGroup
+}
+
+func NewLimiter(options LimiterOptions) Limiter {
+	var limiter = &tokenLimiter{
+		subnets:      newLimiterGroup(options.SubnetLimit, options.SubnetBurst),
+		destinations: newLimiterGroup(options.DestinationLimit, options.DestinationBurst),
+	}
+	if options.GlobalLimit > 0 {
+		limiter.global = rate.NewLimiter(rate.Limit(options.GlobalLimit), burstOf(options.GlobalLimit, options.GlobalBurst))
+	}
+	return limiter
+}
+
+func newLimiterGroup(limit int, burst int) *limiterGroup {
+	if limit <= 0 {
+		return nil
+	}
+	return &limiterGroup{
+		limit:     rate.Limit(limit),
+		burst:     burstOf(limit, burst),
+		limiters:  make(map[string]*keyedLimiter),
+		lastSweep: time.Now(),
+	}
+}
+
+// burstOf default burst is one second of limit
+func burstOf(limit int, burst int) int {
+	if burst <= 0 {
+		return limit
+	}
+	return burst
+}
+
+// Wait destination is waited first, so tokens of wider limits are not
+// held while waiting narrower one. hostname target resolved by filter is
+// limited by its ip, so hostnames of one ip share limits of the ip
+func (limiter *tokenLimiter) Wait(ctx context.Context, target detector.Target) error {
+	var (
+		probes = target.Probes()
+		host   = targetHost(target)
+	)
+	if ip := target.ResolvedIP(); ip != nil {
+		host = ip.String()
+	}
+	limiter.lock.Lock()
+	var destination = limiter.destinations.get(host)
+	var subnet = limiter.subnets.get(subnetKey(host))
+	limiter.lock.Unlock()
+
+	for _, l := range []*rate.Limiter{destination, subnet, limiter.global} {
+		if l == nil {
+			continue
+		}
+		// probes more than burst can never be allowed at once
+		var n = probes
+		if n > l.Burst() {
+			n = l.Burst()
+		}
+		if err := l.WaitN(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// get return limiter of key and remove idle limiters, caller must hold
+// the lock
+func (group *limiterGroup) get(key string) *rate.Limiter {
+	if group == nil || key == "" {
+		return nil
+	}
+	var now = time.Now()
+	if now.Sub(group.lastSweep) > limiterIdleTimeout {
+		for k, l := range group.limiters {
+			if now.Sub(l.lastUsed) > limiterIdleTimeout {
+				delete(group.limiters, k)
+			}
+		}
+		group.lastSweep = now
+	}
+	l, ok := group.limiters[key]
+	if !ok {
+		l = &keyedLimiter{limiter: rate.NewLimiter(group.limit, group.burst)}
+		group.limiters[key] = l
+	}
+	l.lastUsed = now
+	return l.limiter
+}
+
+// subnetKey return /24 network of ipv4 and /64 network of ipv6, hostname
+// not resolved by filter has no subnet
+func subnetKey(host string) string {
+	var ip = net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(64, 128)).String()
+}
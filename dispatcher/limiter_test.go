@@ -0,0 +1,86 @@
+package dispatcher
+
+import (
+	"context"
+	"detect-server/detector"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSubnetKey(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{host: "10.1.2.3", want: "10.1.2.0"},
+		{host: "10.1.2.255", want: "10.1.2.0"},
+		{host: "2001:db8:1:2:3::1", want: "2001:db8:1:2::"},
+		{host: "example.com", want: ""},
+	}
+	for _, tt := range tests {
+		if got := subnetKey(tt.host); got != tt.want {
+			t.Errorf("subnetKey(%s) = %s, want %s", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestTokenLimiter_Wait(t *testing.T) {
+	// address host=ip is hostname resolved by filter
+	var newTarget = func(address string) detector.Target {
+		var host, ip, resolved = strings.Cut(address, "=")
+		var target = detector.NewDetectTarget(detector.ICMPDetect, host, detector.DetectOptions[detector.IcmpOptions]{})
+		if resolved {
+			return target.WithResolved([]net.IP{net.ParseIP(ip)})
+		}
+		return target
+	}
+	tests := []struct {
+		name    string
+		options LimiterOptions
+		targets []string
+		// least time all targets take
+		min time.Duration
+	}{
+		{name: "no limit", options: LimiterOptions{},
+			targets: []string{"10.0.0.1", "10.0.0.1", "10.0.0.1", "10.0.0.1"}},
+		{name: "destination", options: LimiterOptions{DestinationLimit: 20, DestinationBurst: 1},
+			targets: []string{"10.0.0.1", "10.0.0.1", "10.0.0.1", "10.0.0.2"}, min: 100 * time.Millisecond},
+		{name: "subnet", options: LimiterOptions{SubnetLimit: 20, SubnetBurst: 1},
+			targets: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.1.1"}, min: 100 * time.Millisecond},
+		{name: "destination of resolved hostnames", options: LimiterOptions{DestinationLimit: 20, DestinationBurst: 1},
+			targets: []string{"a.example=10.0.0.1", "b.example=10.0.0.1", "10.0.0.1", "c.example=10.0.0.2"}, min: 100 * time.Millisecond},
+		{name: "subnet of resolved hostnames", options: LimiterOptions{SubnetLimit: 20, SubnetBurst: 1},
+			targets: []string{"a.example=10.0.0.1", "b.example=10.0.0.2", "c.example=10.0.0.3", "d.example=10.0.1.1"}, min: 100 * time.Millisecond},
+		{name: "global", options: LimiterOptions{GlobalLimit: 20, GlobalBurst: 1},
+			targets: []string{"10.0.0.1", "10.0.1.1", "10.0.2.1", "10.0.3.1"}, min: 150 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var limiter = NewLimiter(tt.options)
+			var start = time.Now()
+			for _, address := range tt.targets {
+				if err := limiter.Wait(context.Background(), newTarget(address)); err != nil {
+					t.Fatalf("Wait() error = %v", err)
+				}
+			}
+			if elapsed := time.Since(start); elapsed < tt.min || elapsed > tt.min+time.Second {
+				t.Errorf("Wait() took %v, want about %v", elapsed, tt.min)
+			}
+		})
+	}
+}
+
+func TestTokenLimiter_WaitCanceled(t *testing.T) {
+	var limiter = NewLimiter(LimiterOptions{DestinationLimit: 1, DestinationBurst: 1})
+	var target = detector.NewDetectTarget(detector.ICMPDetect, "10.0.0.1", detector.DetectOptions[detector.IcmpOptions]{})
+	if err := limiter.Wait(context.Background(), target); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	var ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx, target); err == nil {
+		t.Errorf("Wait() should fail when ctx is done before tokens are available")
+	}
+}
@@ -0,0 +1,142 @@
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/viper"
+	"sync"
+)
+
+// Priority class of task, targets of every class wait in separate queues
+// and reach detectors in proportion to weight of the class
+type Priority = string
+
+const (
+	PriorityInteractive Priority = "interactive"
+	PriorityNormal      Priority = "normal"
+	PriorityBulk        Priority = "bulk"
+)
+
+// priorities from highest to lowest
+var priorities = []Priority{PriorityInteractive, PriorityNormal, PriorityBulk}
+
+// ParsePriority empty name means normal priority
+func ParsePriority(name string) (Priority, error) {
+	if name == "" {
+		return PriorityNormal, nil
+	}
+	for _, priority := range priorities {
+		if name == priority {
+			return priority, nil
+		}
+	}
+	return "", fmt.Errorf("unknown priority %s", name)
+}
+
+// PriorityOptions Weights targets of every class taken by detectors in
+// one round, e.g. 8 interactive, 4 normal and 1 bulk targets
+type PriorityOptions struct {
+	Weights map[Priority]int
+}
+
+func NewPriorityOptions() PriorityOptions {
+	var options = PriorityOptions{
+		Weights: map[Priority]int{
+			PriorityInteractive: viper.GetInt("dispatcher.priority.weights.interactive"),
+			PriorityNormal:      viper.GetInt("dispatcher.priority.weights.normal"),
+			PriorityBulk:        viper.GetInt("dispatcher.priority.weights.bulk"),
+		},
+	}
+
+	var defaults = map[Priority]int{PriorityInteractive: 8, PriorityNormal: 4, PriorityBulk: 1}
+	for priority, weight := range options.Weights {
+		if weight <= 0 {
+			options.Weights[priority] = defaults[priority]
+		}
+	}
+	return options
+}
+
+// weightedPicker smooth weighted round robin, every ready priority is
+// picked in proportion to its weight and picks of one priority are spread
+// over the round instead of in a row
+type weightedPicker struct {
+	weights map[Priority]int
+	current map[Priority]int
+}
+
+func newWeightedPicker(weights map[Priority]int) *weightedPicker {
+	return &weightedPicker{
+		weights: weights,
+		current: make(map[Priority]int, len(priorities)),
+	}
+}
+
+// pick choose one of priorities ready returns true, false if none is ready
+func (picker *weightedPicker) pick(ready func(Priority) bool) (Priority, bool) {
+	var (
+		picked Priority
+		found  bool
+		total  int
+	)
+	for _, priority := range priorities {
+		if !ready(priority) {
+			continue
+		}
+		var weight = picker.weights[priority]
+		if weight <= 0 {
+			weight = 1
+		}
+		total += weight
+		picker.current[priority] += weight
+		if !found || picker.current[priority] > picker.current[picked] {
+			picked, found = priority, true
+		}
+	}
+	if found {
+		picker.current[picked] -= total
+	}
+	return picked, found
+}
+
+// taskQueue unbounded fifo of tasks of one priority, tasks are received
+// from connector at once so task of other priority is never blocked
+type taskQueue struct {
+	lock  sync.Mutex
+	tasks []Task
+	ready chan struct{}
+}
+
+func newTaskQueue() *taskQueue {
+	return &taskQueue{ready: make(chan struct{}, 1)}
+}
+
+func (queue *taskQueue) push(task Task) {
+	queue.lock.Lock()
+	queue.tasks = append(queue.tasks, task)
+	queue.lock.Unlock()
+	select {
+	case queue.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pop wait next task until ctx is done
+func (queue *taskQueue) pop(ctx context.Context) (Task, bool) {
+	for {
+		queue.lock.Lock()
+		if len(queue.tasks) > 0 {
+			var task = queue.tasks[0]
+			queue.tasks[0] = nil
+			queue.tasks = queue.tasks[1:]
+			queue.lock.Unlock()
+			return task, true
+		}
+		queue.lock.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-queue.ready:
+		}
+	}
+}
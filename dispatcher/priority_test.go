@@ -0,0 +1,226 @@
+package dispatcher
+
+import (
+	"detect-server/connector"
+	"detect-server/detector"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParsePriority(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Priority
+		wantErr bool
+	}{
+		{name: "", want: PriorityNormal},
+		{name: "interactive", want: PriorityInteractive},
+		{name: "bulk", want: PriorityBulk},
+		{name: "urgent", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePriority(tt.name)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ParsePriority() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestWeightedPicker_Pick(t *testing.T) {
+	var weights = map[Priority]int{PriorityInteractive: 4, PriorityNormal: 2, PriorityBulk: 1}
+	tests := []struct {
+		name  string
+		ready []Priority
+		want  map[Priority]int
+	}{
+		{name: "all ready", ready: priorities,
+			want: map[Priority]int{PriorityInteractive: 8, PriorityNormal: 4, PriorityBulk: 2}},
+		{name: "bulk only", ready: []Priority{PriorityBulk}, want: map[Priority]int{PriorityBulk: 14}},
+		{name: "interactive and bulk", ready: []Priority{PriorityInteractive, PriorityBulk},
+			want: map[Priority]int{PriorityInteractive: 11, PriorityBulk: 3}},
+		{name: "none ready", want: map[Priority]int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var picker = newWeightedPicker(weights)
+			var ready = func(priority Priority) bool {
+				for _, p := range tt.ready {
+					if p == priority {
+						return true
+					}
+				}
+				return false
+			}
+			var got = make(map[Priority]int)
+			for i := 0; i < 14; i++ {
+				if priority, ok := picker.pick(ready); ok {
+					got[priority]++
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pick() counts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommonDispatcher_Priority(t *testing.T) {
+	var (
+		icmpDetector = newEchoDetector[detector.IcmpOptions, *detector.IcmpStatistics]()
+		receiver     = connector.NewChanConnector[Task](connector.Options{MaxBufferSize: 10})
+		publisher    = connector.NewChanConnector[any](connector.Options{MaxBufferSize: 100})
+		dispatch     = NewDispatcher(Options{Priority: PriorityOptions{Weights: map[Priority]int{
+			PriorityInteractive: 8, PriorityNormal: 4, PriorityBulk: 1}}})
+		tracker   = NewTracker(TrackerOptions{Retention: time.Minute})
+		broker    = NewBroker(BrokerOptions{SubscriberBufferSize: 10})
+		filter, _ = NewFilter(FilterOptions{})
+	)
+	// one target is detected every 5ms
+	icmpDetector.targets = make(chan detector.DetectTarget[detector.IcmpOptions], 1)
+	icmpDetector.detect = func(target detector.DetectTarget[detector.IcmpOptions]) detector.DetectResult[detector.IcmpOptions, *detector.IcmpStatistics] {
+		time.Sleep(5 * time.Millisecond)
+		return detector.NewDetectResult[detector.IcmpOptions, *detector.IcmpStatistics](target, nil, nil)
+	}
+	_ = icmpDetector.Start()
+	defer icmpDetector.Stop()
+	dispatch.AddReceiver(receiver)
+	dispatch.AddRoute(NewRoute[detector.IcmpOptions, *detector.IcmpStatistics](detector.ICMPDetect, icmpDetector,
+		NewDefaultProcessor[detector.IcmpOptions, *detector.IcmpStatistics, DefaultMessage]()))
+	dispatch.AddPublisher(publisher)
+	dispatch.AddTracker(tracker)
+	dispatch.AddBroker(broker)
+	dispatch.AddFilter(filter)
+	dispatch.AddLimiter(NewLimiter(LimiterOptions{}))
+	if err := dispatch.Start(); err != nil {
+		t.Fatalf("start dispatcher failed. %s", err)
+	}
+	defer dispatch.Stop()
+
+	var newTask = func(priority Priority, count int) Task {
+		var detects = make([]detector.Target, 0, count)
+		for i := 0; i < count; i++ {
+			detects = append(detects, detector.NewDetectTarget(detector.ICMPDetect, fmt.Sprintf("10.0.%d.%d", i/256, i%256),
+				detector.DetectOptions[detector.IcmpOptions]{}))
+		}
+		var task = NewTask(priority, priority, detector.NewSliceIterator(detects...))
+		tracker.Track(task)
+		return task
+	}
+	var bulk, normal = newTask(PriorityBulk, 1000), newTask(PriorityNormal, 1000)
+	receiver.Publish() <- bulk
+	receiver.Publish() <- normal
+	// sweeps fill route queues and detector buffer before interactive task
+	time.Sleep(50 * time.Millisecond)
+	var interactive = newTask(PriorityInteractive, 1)
+	receiver.Publish() <- interactive
+
+	var counts = make(map[string]int)
+	for {
+		select {
+		case msg := <-publisher.Receive():
+			var message = msg.(DefaultMessage)
+			counts[message.TaskId]++
+			if message.TaskId != interactive.Id() {
+				continue
+			}
+			// interactive target waits at most for targets held by route
+			// and detector, not for the sweeps
+			if status, _ := tracker.Get(interactive.Id()); status.State != TaskCompleted {
+				t.Errorf("interactive task status = %+v, want completed", status)
+			}
+			if waited := counts[bulk.Id()] + counts[normal.Id()]; waited > 20 {
+				t.Errorf("interactive target waits for %d targets of sweeps", waited)
+			}
+			if counts[normal.Id()] < counts[bulk.Id()] {
+				t.Errorf("normal targets %d are fewer than bulk targets %d", counts[normal.Id()], counts[bulk.Id()])
+			}
+			return
+		case <-time.After(5 * time.Second):
+			t.Fatalf("wait interactive result timeout, received %v", counts)
+		}
+	}
+}
+
+func TestCommonDispatcher_PriorityBacklog(t *testing.T) {
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/bulk" {
+			time.Sleep(20 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	// detector with default options, its buffer must not hold a backlog
+	var options = detector.NewHttpDetectorOptions()
+	options.MaxResultQueueSize = 1000
+	var httpDetector = detector.NewHttpDetector(options)
+	if err := httpDetector.Start(); err != nil {
+		t.Fatalf("start http detector failed. %s", err)
+	}
+	defer httpDetector.Stop()
+	var (
+		receiver  = connector.NewChanConnector[Task](connector.Options{MaxBufferSize: 10})
+		publisher = connector.NewChanConnector[any](connector.Options{MaxBufferSize: 1000})
+		dispatch  = NewDispatcher(Options{Priority: PriorityOptions{Weights: map[Priority]int{
+			PriorityInteractive: 8, PriorityNormal: 4, PriorityBulk: 1}}})
+		tracker   = NewTracker(TrackerOptions{Retention: time.Minute})
+		filter, _ = NewFilter(FilterOptions{})
+	)
+	dispatch.AddReceiver(receiver)
+	dispatch.AddRoute(NewRoute[detector.HttpOptions, *detector.HttpStatistics](detector.HTTPDetect, httpDetector,
+		NewDefaultProcessor[detector.HttpOptions, *detector.HttpStatistics, DefaultMessage]()))
+	dispatch.AddPublisher(publisher)
+	dispatch.AddTracker(tracker)
+	dispatch.AddBroker(NewBroker(BrokerOptions{SubscriberBufferSize: 10}))
+	dispatch.AddFilter(filter)
+	dispatch.AddLimiter(NewLimiter(LimiterOptions{}))
+	if err := dispatch.Start(); err != nil {
+		t.Fatalf("start dispatcher failed. %s", err)
+	}
+	defer dispatch.Stop()
+
+	var newTask = func(priority Priority, path string, count int) Task {
+		var detects = make([]detector.Target, 0, count)
+		for i := 0; i < count; i++ {
+			detects = append(detects, detector.NewDetectTarget(detector.HTTPDetect, server.URL+path,
+				detector.DetectOptions[detector.HttpOptions]{}))
+		}
+		var task = NewTask(priority, priority, detector.NewSliceIterator(detects...))
+		tracker.Track(task)
+		return task
+	}
+	var bulk = newTask(PriorityBulk, "/bulk", 300)
+	receiver.Publish() <- bulk
+	// backlog of bulk fills runners, detector buffer and route queue
+	time.Sleep(100 * time.Millisecond)
+	var interactive = newTask(PriorityInteractive, "/interactive", 1)
+	var before, _ = tracker.Get(bulk.Id())
+	receiver.Publish() <- interactive
+
+	for {
+		select {
+		case msg := <-publisher.Receive():
+			var message = msg.(DefaultMessage)
+			if message.TaskId != interactive.Id() {
+				continue
+			}
+			if !message.Summary.Success {
+				t.Errorf("interactive result = %+v, want success", message)
+			}
+			// interactive target waits only for bulk targets being detected
+			// and the few held by detector buffer and route, not the backlog
+			var after, _ = tracker.Get(bulk.Id())
+			if waited := after.Completed - before.Completed; waited > 2*options.MaxRunnerCount+3 {
+				t.Errorf("interactive target is detected after %d bulk targets, %d bulk targets are left", waited, after.Total-after.Completed)
+			}
+			return
+		case <-time.After(5 * time.Second):
+			t.Fatalf("wait interactive result timeout")
+		}
+	}
+}
@@ -1,13 +1,46 @@
 package dispatcher
 
-import "detect-server/detector"
+import (
+	"detect-server/detector"
+	"encoding/json"
+)
 
+// DefaultMessage Summary is shared by all protocols, Detail is the
+// protocol specific result
 type DefaultMessage struct {
-	Type   detector.DetectType
-	Target string
-	Count  int
-	Detail any
-	Error  error
+	TaskId  string
+	Type    detector.DetectType
+	Target  string
+	Count   int
+	Summary detector.Summary
+	Detail  any
+	Error   error
+}
+
+// MarshalJSON encode Error as its message
+func (message DefaultMessage) MarshalJSON() ([]byte, error) {
+	type plainMessage DefaultMessage
+	var out = struct {
+		plainMessage
+		Error string `json:",omitempty"`
+	}{
+		plainMessage: plainMessage(message),
+	}
+	if message.Error != nil {
+		out.Error = message.Error.Error()
+	}
+	return json.Marshal(out)
+}
+
+// errorMessage message of target can not be detected
+func errorMessage(target detector.Target, err error) DefaultMessage {
+	return DefaultMessage{
+		TaskId:  target.TaskId(),
+		Type:    target.DetectType(),
+		Target:  target.Address(),
+		Summary: detector.Summary{ErrorClass: detector.ClassifyError(err)},
+		Error:   err,
+	}
 }
 
 type MessageOutput interface {
@@ -23,11 +56,13 @@ type defaultProcessor[T detector.DetectInput, R detector.DetectOutput, F Message
 
 func (process *defaultProcessor[T, R, F]) Process(in detector.DetectResult[T, R]) F {
 	var out = F(DefaultMessage{
-		Type:   in.Target.Type,
-		Target: in.Target.Target,
-		Count:  in.Target.Options.Count,
-		Detail: in.Result,
-		Error:  in.Error,
+		TaskId:  in.Target.Task,
+		Type:    in.Target.Type,
+		Target:  in.Target.Target,
+		Count:   in.Target.Options.Count,
+		Summary: in.Summary(),
+		Detail:  in.Result,
+		Error:   in.Error,
 	})
 	return out
 }
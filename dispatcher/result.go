@@ -0,0 +1,117 @@
+package dispatcher
+
+import (
+	"detect-server/detector"
+	"time"
+)
+
+// TargetResult result of single target in task
+type TargetResult struct {
+	Type       detector.DetectType `json:"type"`
+	Target     string              `json:"target"`
+	Success    bool                `json:"success"`
+	Loss       float64             `json:"loss"`
+	MinLatency time.Duration       `json:"minLatency"`
+	AvgLatency time.Duration       `json:"avgLatency"`
+	MaxLatency time.Duration       `json:"maxLatency"`
+	ErrorClass detector.ErrorClass `json:"errorClass,omitempty"`
+	Error      string              `json:"error,omitempty"`
+}
+
+// TaskResult aggregated results of task. Alive counts success targets, Dead
+// counts targets detected but not success, Errors counts targets can not be
+// detected, Filtered counts targets dropped by filter. latency statistics
+// are calculated from all latency samples
+type TaskResult struct {
+	TaskId     string         `json:"taskId"`
+	Name       string         `json:"name"`
+	State      TaskState      `json:"state"`
+	Total      int            `json:"total"`
+	Alive      int            `json:"alive"`
+	Dead       int            `json:"dead"`
+	Errors     int            `json:"errors"`
+	Filtered   int            `json:"filtered"`
+	MinLatency time.Duration  `json:"minLatency"`
+	AvgLatency time.Duration  `json:"avgLatency"`
+	MaxLatency time.Duration  `json:"maxLatency"`
+	Targets    []TargetResult `json:"targets,omitempty"`
+
+	latencyTotal time.Duration
+	latencyCount int
+}
+
+// TaskCompletedMessage published when all targets of task are done
+type TaskCompletedMessage struct {
+	Event  string     `json:"event"`
+	Result TaskResult `json:"result"`
+}
+
+const TaskCompletedEvent = "task_completed"
+
+// maxPreallocatedTargets limit capacity allocated for results of large task
+const maxPreallocatedTargets = 1024
+
+func newTaskResult(task Task) *TaskResult {
+	var total = task.Targets().Count()
+	var capacity = total
+	if capacity > maxPreallocatedTargets {
+		capacity = maxPreallocatedTargets
+	}
+	return &TaskResult{
+		TaskId:  task.Id(),
+		Name:    task.Name(),
+		Total:   total,
+		Targets: make([]TargetResult, 0, capacity),
+	}
+}
+
+// add aggregate result of one target into task result
+func (result *TaskResult) add(message DefaultMessage) {
+	var target = TargetResult{
+		Type:       message.Type,
+		Target:     message.Target,
+		Success:    message.Summary.Success,
+		Loss:       message.Summary.Loss,
+		MinLatency: message.Summary.MinLatency,
+		AvgLatency: message.Summary.AvgLatency,
+		MaxLatency: message.Summary.MaxLatency,
+		ErrorClass: message.Summary.ErrorClass,
+	}
+	switch {
+	case message.Error != nil:
+		target.Error = message.Error.Error()
+		result.Errors++
+	case message.Summary.Success:
+		result.Alive++
+	default:
+		result.Dead++
+	}
+	result.Targets = append(result.Targets, target)
+
+	for _, latency := range message.Summary.Latencies {
+		result.latencyTotal += latency
+		result.latencyCount++
+		if result.MinLatency == 0 || latency < result.MinLatency {
+			result.MinLatency = latency
+		}
+		if latency > result.MaxLatency {
+			result.MaxLatency = latency
+		}
+	}
+	if result.latencyCount > 0 {
+		result.AvgLatency = result.latencyTotal / time.Duration(result.latencyCount)
+	}
+}
+
+// copy return result can be used out of tracker lock, targets are
+// omitted when withTargets is false
+func (result *TaskResult) copy(withTargets bool) TaskResult {
+	var out = *result
+	if withTargets {
+		out.Targets = make([]TargetResult, len(result.Targets))
+		copy(out.Targets, result.Targets)
+	} else {
+		out.Targets = nil
+	}
+	return out
+}
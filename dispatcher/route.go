@@ -0,0 +1,170 @@
+package dispatcher
+
+import (
+	"context"
+	"detect-server/detector"
+	"fmt"
+	"github.com/google/uuid"
+	"strings"
+	"sync"
+)
+
+// syncTaskPrefix prefix of task id bound to target detected sync, result
+// of the target is returned to its waiter instead of handler of Forward
+const syncTaskPrefix = "sync:"
+
+// Route bind detector and processor of one protocol, it hides the
+// generic types so dispatcher can hold detectors of all protocols
+type Route interface {
+	Type() detector.DetectType
+	// Dispatch send target to queue of priority async, it blocks while
+	// queue is full until ctx is done
+	Dispatch(ctx context.Context, target detector.Target, priority Priority) error
+	// Schedule move targets from queues of priorities to detector by
+	// weights of priorities until ctx is done
+	Schedule(ctx context.Context, weights map[Priority]int)
+	// Detect send target to interactive queue and wait its result until
+	// ctx is done, target is bound to ctx so probes stop with it
+	Detect(ctx context.Context, target detector.Target) (DefaultMessage, error)
+	// Forward process results of detector and pass them to handler
+	// until ctx is done
+	Forward(ctx context.Context, handler func(DefaultMessage))
+}
+
+// route queue of every priority holds one target, so targets wait in
+// order of weights instead of in the detector buffer
+type route[T detector.DetectInput, R detector.DetectOutput] struct {
+	detectType detector.DetectType
+	detector   detector.Detector[T, R]
+	processor  Processor[T, R, DefaultMessage]
+	queues     map[Priority]chan detector.DetectTarget[T]
+	// waiters receive results of targets detected sync by task id
+	waitersLock sync.Mutex
+	waiters     map[string]chan DefaultMessage
+}
+
+func NewRoute[T detector.DetectInput, R detector.DetectOutput](detectType detector.DetectType,
+	detector detector.Detector[T, R], processor Processor[T, R, DefaultMessage]) Route {
+	return &route[T, R]{
+		detectType: detectType,
+		detector:   detector,
+		processor:  processor,
+		queues:     newPriorityQueues[T](),
+		waiters:    make(map[string]chan DefaultMessage),
+	}
+}
+
+func newPriorityQueues[T detector.DetectInput]() map[Priority]chan detector.DetectTarget[T] {
+	var queues = make(map[Priority]chan detector.DetectTarget[T], len(priorities))
+	for _, priority := range priorities {
+		queues[priority] = make(chan detector.DetectTarget[T], 1)
+	}
+	return queues
+}
+
+func (r *route[T, R]) Type() detector.DetectType {
+	return r.detectType
+}
+
+func (r *route[T, R]) Dispatch(ctx context.Context, target detector.Target, priority Priority) error {
+	detect, ok := target.(detector.DetectTarget[T])
+	if !ok {
+		return fmt.Errorf("target %s is not %s target", target.Address(), r.detectType)
+	}
+	queue, ok := r.queues[priority]
+	if !ok {
+		return fmt.Errorf("unknown priority %s", priority)
+	}
+	select {
+	case queue <- detect:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Schedule is the only receiver of queues, so target of picked priority
+// is received without blocking. buffer of detector holds one target by
+// default, so next target is picked when a runner is free and target of
+// higher priority never waits behind a backlog of lower ones
+func (r *route[T, R]) Schedule(ctx context.Context, weights map[Priority]int) {
+	var picker = newWeightedPicker(weights)
+	var ready = func(priority Priority) bool {
+		return len(r.queues[priority]) > 0
+	}
+	for {
+		var detect detector.DetectTarget[T]
+		if priority, ok := picker.pick(ready); ok {
+			detect = <-r.queues[priority]
+		} else {
+			select {
+			case <-ctx.Done():
+				return
+			case detect = <-r.queues[PriorityInteractive]:
+			case detect = <-r.queues[PriorityNormal]:
+			case detect = <-r.queues[PriorityBulk]:
+			}
+		}
+		select {
+		case r.detector.Detects() <- detect:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *route[T, R]) Detect(ctx context.Context, target detector.Target) (DefaultMessage, error) {
+	if _, ok := target.(detector.DetectTarget[T]); !ok {
+		return DefaultMessage{}, fmt.Errorf("target %s is not %s target", target.Address(), r.detectType)
+	}
+	var id = syncTaskPrefix + uuid.NewString()
+	var reply = make(chan DefaultMessage, 1)
+	r.waitersLock.Lock()
+	r.waiters[id] = reply
+	r.waitersLock.Unlock()
+	defer func() {
+		r.waitersLock.Lock()
+		delete(r.waiters, id)
+		r.waitersLock.Unlock()
+	}()
+
+	if err := r.Dispatch(ctx, target.WithTask(id).WithContext(ctx), PriorityInteractive); err != nil {
+		return DefaultMessage{}, err
+	}
+	select {
+	case message := <-reply:
+		message.TaskId = ""
+		return message, nil
+	case <-ctx.Done():
+		return DefaultMessage{}, ctx.Err()
+	}
+}
+
+// reply pass result of target detected sync to its waiter, result whose
+// waiter is gone is dropped. false if message is not result of sync target
+func (r *route[T, R]) reply(message DefaultMessage) bool {
+	if !strings.HasPrefix(message.TaskId, syncTaskPrefix) {
+		return false
+	}
+	r.waitersLock.Lock()
+	reply, ok := r.waiters[message.TaskId]
+	r.waitersLock.Unlock()
+	if ok {
+		reply <- message
+	}
+	return true
+}
+
+func (r *route[T, R]) Forward(ctx context.Context, handler func(DefaultMessage)) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case result := <-r.detector.Results():
+			var message = r.processor.Process(result)
+			if !r.reply(message) {
+				handler(message)
+			}
+		}
+	}
+}
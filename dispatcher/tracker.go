@@ -0,0 +1,379 @@
+package dispatcher
+
+import (
+	"detect-server/log"
+	"detect-server/storage"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"sync"
+	"time"
+)
+
+type TaskState = string
+
+const (
+	TaskPending   TaskState = "pending"
+	TaskRunning   TaskState = "running"
+	TaskCompleted TaskState = "completed"
+	TaskCancelled TaskState = "cancelled"
+)
+
+var (
+	ErrTaskNotFound = errors.New("task not found")
+	ErrTaskFinished = errors.New("task is finished")
+)
+
+// TaskStatus lifecycle and progress of task, Completed counts targets which
+// result is received, Failed counts completed targets not success, Filtered
+// counts targets dropped by filter
+type TaskStatus struct {
+	Id         string    `json:"id"`
+	Name       string    `json:"name"`
+	Priority   Priority  `json:"priority"`
+	State      TaskState `json:"state"`
+	Total      int       `json:"total"`
+	Dispatched int       `json:"dispatched"`
+	Completed  int       `json:"completed"`
+	Failed     int       `json:"failed"`
+	Filtered   int       `json:"filtered"`
+	CreatedAt  time.Time `json:"createdAt"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+}
+
+// Finished task will not change any more
+func (status TaskStatus) Finished() bool {
+	return status.State == TaskCompleted || status.State == TaskCancelled
+}
+
+type TrackerOptions struct {
+	// Retention how long finished task is kept
+	Retention time.Duration
+}
+
+func NewTrackerOptions() TrackerOptions {
+	var options = TrackerOptions{
+		Retention: time.Duration(viper.GetInt("dispatcher.task.retention")) * time.Second,
+	}
+
+	if options.Retention <= 0 {
+		options.Retention = time.Hour
+	}
+	return options
+}
+
+// Tracker record lifecycle and progress of tasks
+type Tracker interface {
+	// Track register task as pending
+	Track(task Task) TaskStatus
+	// Start mark task running, finished is true when task has no targets
+	Start(id string) (finished bool)
+	// Dispatched count target of task sent to detector
+	Dispatched(id string)
+	// Done count and aggregate result of task target, finished is true
+	// when the result completes the task
+	Done(message DefaultMessage) (finished bool)
+	// Filtered count target of task dropped by filter, finished is true
+	// when the target completes the task
+	Filtered(id string) (finished bool)
+	// Cancel mark unfinished task cancelled, results of task received
+	// later are ignored
+	Cancel(id string) (TaskStatus, error)
+	Get(id string) (TaskStatus, bool)
+	// Unfinished return status of pending and running tasks
+	Unfinished() []TaskStatus
+	// Result return aggregated results of task
+	Result(id string, withTargets bool) (TaskResult, bool)
+	// AddStore persist tasks into store, tasks are kept in memory only
+	// without store
+	AddStore(store storage.Store)
+	// Load restore tasks from store, unfinished tasks are cancelled
+	// since their targets are lost with the last process
+	Load() error
+}
+
+type trackedTask struct {
+	status TaskStatus
+	result *TaskResult
+}
+
+// taskRecord stored form of task
+type taskRecord struct {
+	Status TaskStatus `json:"status"`
+	Result TaskResult `json:"result"`
+}
+
+type memoryTracker struct {
+	options TrackerOptions
+	lock    sync.RWMutex
+	tasks   map[string]*trackedTask
+	store   storage.Store
+	// storeLock keeps snapshots of task written in order
+	storeLock sync.Mutex
+}
+
+func NewTracker(options TrackerOptions) Tracker {
+	return &memoryTracker{
+		options: options,
+		tasks:   make(map[string]*trackedTask),
+	}
+}
+
+func (tracker *memoryTracker) AddStore(store storage.Store) {
+	tracker.store = store
+}
+
+func (tracker *memoryTracker) Load() error {
+	if tracker.store == nil {
+		return nil
+	}
+	var (
+		now         = time.Now()
+		deadline    = now.Add(-tracker.options.Retention)
+		expired     []string
+		interrupted []string
+		count       int
+	)
+	tracker.lock.Lock()
+	var err = tracker.store.ForEach(storage.TaskBucket, func(key string, data []byte) error {
+		var record taskRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			log.Logger.Warnf("decode stored task %s failed. %s", key, err)
+			return nil
+		}
+		if !record.Status.Finished() {
+			record.Status.State = TaskCancelled
+			record.Status.FinishedAt = now
+			interrupted = append(interrupted, key)
+		}
+		if record.Status.FinishedAt.Before(deadline) {
+			expired = append(expired, key)
+			return nil
+		}
+		var result = record.Result
+		result.State = record.Status.State
+		tracker.tasks[key] = &trackedTask{status: record.Status, result: &result}
+		count++
+		return nil
+	})
+	tracker.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range expired {
+		tracker.remove(id)
+	}
+	// persist cancelled state of interrupted tasks
+	for _, id := range interrupted {
+		tracker.save(id)
+	}
+	log.Logger.Infof("load %d tasks from store, %d unfinished tasks are cancelled", count, len(interrupted))
+	return nil
+}
+
+// save write snapshot of task into store
+func (tracker *memoryTracker) save(id string) {
+	if tracker.store == nil {
+		return
+	}
+	tracker.storeLock.Lock()
+	defer tracker.storeLock.Unlock()
+
+	tracker.lock.RLock()
+	tracked, ok := tracker.tasks[id]
+	if !ok {
+		tracker.lock.RUnlock()
+		return
+	}
+	var record = taskRecord{Status: tracked.status, Result: tracked.result.copy(true)}
+	tracker.lock.RUnlock()
+
+	if err := tracker.store.Put(storage.TaskBucket, id, record); err != nil {
+		log.Logger.Errorf("store task %s failed. %s", id, err)
+	}
+}
+
+// remove delete task from store
+func (tracker *memoryTracker) remove(id string) {
+	if tracker.store == nil {
+		return
+	}
+	if err := tracker.store.Delete(storage.TaskBucket, id); err != nil {
+		log.Logger.Errorf("delete stored task %s failed. %s", id, err)
+	}
+}
+
+func (tracker *memoryTracker) Track(task Task) TaskStatus {
+	var status, purged = tracker.track(task)
+	for _, id := range purged {
+		tracker.remove(id)
+	}
+	tracker.save(status.Id)
+	return status
+}
+
+func (tracker *memoryTracker) track(task Task) (TaskStatus, []string) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	var purged = tracker.purge()
+	var tracked = &trackedTask{
+		status: TaskStatus{
+			Id:        task.Id(),
+			Name:      task.Name(),
+			Priority:  task.Priority(),
+			State:     TaskPending,
+			Total:     task.Targets().Count(),
+			CreatedAt: time.Now(),
+		},
+		result: newTaskResult(task),
+	}
+	tracker.tasks[task.Id()] = tracked
+	return tracked.status, purged
+}
+
+// purge remove finished tasks exceed retention, return ids of removed tasks
+func (tracker *memoryTracker) purge() []string {
+	var (
+		deadline = time.Now().Add(-tracker.options.Retention)
+		purged   []string
+	)
+	for id, tracked := range tracker.tasks {
+		if tracked.status.Finished() && tracked.status.FinishedAt.Before(deadline) {
+			delete(tracker.tasks, id)
+			purged = append(purged, id)
+		}
+	}
+	return purged
+}
+
+func (tracker *memoryTracker) Start(id string) bool {
+	var finished = tracker.update(id, func(tracked *trackedTask) {
+		if tracked.status.State == TaskPending {
+			tracked.status.State = TaskRunning
+			tracked.status.StartedAt = time.Now()
+		}
+	})
+	tracker.save(id)
+	return finished
+}
+
+func (tracker *memoryTracker) Dispatched(id string) {
+	tracker.update(id, func(tracked *trackedTask) {
+		tracked.status.Dispatched++
+	})
+}
+
+// Done only store task when it is finished, progress is not persisted
+func (tracker *memoryTracker) Done(message DefaultMessage) bool {
+	var finished = tracker.update(message.TaskId, func(tracked *trackedTask) {
+		tracked.status.Completed++
+		if !message.Summary.Success {
+			tracked.status.Failed++
+		}
+		tracked.result.add(message)
+	})
+	if finished {
+		tracker.save(message.TaskId)
+	}
+	return finished
+}
+
+func (tracker *memoryTracker) Filtered(id string) bool {
+	var finished = tracker.update(id, func(tracked *trackedTask) {
+		tracked.status.Filtered++
+		tracked.result.Filtered++
+	})
+	if finished {
+		tracker.save(id)
+	}
+	return finished
+}
+
+// update apply fn to unfinished task and mark it completed when all
+// targets are done, return true if task is completed by this update
+func (tracker *memoryTracker) update(id string, fn func(tracked *trackedTask)) bool {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	tracked, ok := tracker.tasks[id]
+	if !ok || tracked.status.Finished() {
+		return false
+	}
+	fn(tracked)
+	var status = &tracked.status
+	if status.State == TaskRunning && status.Completed+status.Filtered >= status.Total {
+		status.State = TaskCompleted
+		status.FinishedAt = time.Now()
+		tracked.result.State = TaskCompleted
+		return true
+	}
+	tracked.result.State = status.State
+	return false
+}
+
+func (tracker *memoryTracker) Cancel(id string) (TaskStatus, error) {
+	status, err := tracker.cancel(id)
+	if err != nil {
+		return status, err
+	}
+	tracker.save(id)
+	return status, nil
+}
+
+func (tracker *memoryTracker) cancel(id string) (TaskStatus, error) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	tracked, ok := tracker.tasks[id]
+	if !ok {
+		return TaskStatus{}, fmt.Errorf("%w, id %s", ErrTaskNotFound, id)
+	}
+	if tracked.status.Finished() {
+		return tracked.status, fmt.Errorf("%w, id %s state %s", ErrTaskFinished, id, tracked.status.State)
+	}
+	tracked.status.State = TaskCancelled
+	tracked.status.FinishedAt = time.Now()
+	tracked.result.State = TaskCancelled
+	return tracked.status, nil
+}
+
+func (tracker *memoryTracker) Get(id string) (TaskStatus, bool) {
+	tracker.lock.RLock()
+	defer tracker.lock.RUnlock()
+
+	tracked, ok := tracker.tasks[id]
+	if !ok {
+		return TaskStatus{}, false
+	}
+	return tracked.status, true
+}
+
+func (tracker *memoryTracker) Unfinished() []TaskStatus {
+	tracker.lock.RLock()
+	defer tracker.lock.RUnlock()
+
+	var statuses []TaskStatus
+	for _, tracked := range tracker.tasks {
+		if !tracked.status.Finished() {
+			statuses = append(statuses, tracked.status)
+		}
+	}
+	return statuses
+}
+
+func (tracker *memoryTracker) Result(id string, withTargets bool) (TaskResult, bool) {
+	tracker.lock.RLock()
+	defer tracker.lock.RUnlock()
+
+	tracked, ok := tracker.tasks[id]
+	if !ok {
+		return TaskResult{}, false
+	}
+	var result = tracked.result.copy(withTargets)
+	result.State = tracked.status.State
+	return result, true
+}
@@ -0,0 +1,82 @@
+package dispatcher
+
+import (
+	"detect-server/detector"
+	"detect-server/storage"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTracker_Load(t *testing.T) {
+	var options = storage.Options{Path: filepath.Join(t.TempDir(), "detect.db")}
+	store, err := storage.NewStore(options)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	var newTarget = func(address string) detector.Target {
+		return detector.NewDetectTarget(detector.ICMPDetect, address, detector.DetectOptions[detector.IcmpOptions]{})
+	}
+	var (
+		tracker  = NewTracker(TrackerOptions{Retention: time.Minute})
+		finished = NewTask("finished", PriorityNormal, detector.NewSliceIterator(newTarget("10.0.0.1")))
+		running  = NewTask("running", PriorityNormal, detector.NewSliceIterator(newTarget("10.0.0.2"), newTarget("10.0.0.3")))
+		pending  = NewTask("pending", PriorityNormal, detector.NewSliceIterator(newTarget("10.0.0.4")))
+	)
+	tracker.AddStore(store)
+	for _, task := range []Task{finished, running, pending} {
+		tracker.Track(task)
+	}
+	tracker.Start(finished.Id())
+	tracker.Done(DefaultMessage{TaskId: finished.Id(), Type: detector.ICMPDetect, Target: "10.0.0.1",
+		Summary: detector.Summary{Success: true, AvgLatency: time.Millisecond}})
+	tracker.Start(running.Id())
+	tracker.Done(DefaultMessage{TaskId: running.Id(), Type: detector.ICMPDetect, Target: "10.0.0.2"})
+	if err = store.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// tracker of restarted process
+	if store, err = storage.NewStore(options); err != nil {
+		t.Fatalf("NewStore() reopen error = %v", err)
+	}
+	defer store.Close()
+	tracker = NewTracker(TrackerOptions{Retention: time.Minute})
+	tracker.AddStore(store)
+	if err = tracker.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		id    string
+		state TaskState
+		alive int
+	}{
+		{name: "finished task is restored", id: finished.Id(), state: TaskCompleted, alive: 1},
+		{name: "running task is cancelled", id: running.Id(), state: TaskCancelled},
+		{name: "pending task is cancelled", id: pending.Id(), state: TaskCancelled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, ok := tracker.Get(tt.id)
+			if !ok {
+				t.Fatalf("Get() task is not found")
+			}
+			if status.State != tt.state || status.FinishedAt.IsZero() {
+				t.Errorf("Get() = %+v, want state %s with finish time", status, tt.state)
+			}
+			result, ok := tracker.Result(tt.id, true)
+			if !ok || result.State != tt.state || result.Alive != tt.alive {
+				t.Errorf("Result() = %+v, want state %s with %d alive targets", result, tt.state, tt.alive)
+			}
+		})
+	}
+	if unfinished := tracker.Unfinished(); len(unfinished) != 0 {
+		t.Errorf("Unfinished() = %+v, want no task", unfinished)
+	}
+	// results of interrupted task are ignored after restart
+	if tracker.Done(DefaultMessage{TaskId: running.Id(), Target: "10.0.0.3"}) {
+		t.Errorf("Done() completes cancelled task")
+	}
+}
@@ -55,3 +55,21 @@ func InitLogger() {
 
 	Logger = zap.New(fileCore, zap.AddCaller()).Sugar() //AddCaller()为显示文件名和行号
 }
+
+// NewAuditLogger create logger writing json records to path, records are
+// written to Logger if path is empty
+func NewAuditLogger(path string) *zap.SugaredLogger {
+	if path == "" {
+		return Logger.Named("audit")
+	}
+	var encoderConfig = zap.NewProductionEncoderConfig()
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	var writeSyncer = zapcore.AddSync(&lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    10,
+		MaxBackups: 10,
+		MaxAge:     90,
+	})
+	var core = zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig), writeSyncer, zapcore.InfoLevel)
+	return zap.New(core).Sugar()
+}
@@ -0,0 +1,82 @@
+package scheduler
+
+import (
+	"detect-server/detector"
+	"detect-server/dispatcher"
+	"encoding/json"
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"math/rand"
+	"time"
+)
+
+// cronParser parse standard 5 fields cron expression and descriptors
+// like @hourly or @every 5m
+var cronParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// Job recurring detection, it is run every Interval seconds or by Cron
+// expression, every run is delayed randomly at most Jitter seconds.
+// Payload is the body of detect api of Type, empty Type means mixed payload.
+// tasks of job are run by Priority, empty means normal. run is skipped
+// while task of last run is unfinished. fields after Enabled are written
+// by scheduler only
+type Job struct {
+	Id       string              `json:"id"`
+	Name     string              `json:"name" binding:"required"`
+	Type     detector.DetectType `json:"type" binding:"omitempty,oneof=icmp tcp udp http"`
+	Payload  json.RawMessage     `json:"payload" binding:"required"`
+	Interval int                 `json:"interval" binding:"gte=0"`
+	Cron     string              `json:"cron"`
+	Jitter   int                 `json:"jitter" binding:"gte=0"`
+	Priority dispatcher.Priority `json:"priority" binding:"omitempty,oneof=interactive normal bulk"`
+	Enabled  bool                `json:"enabled"`
+
+	CreatedAt  time.Time `json:"createdAt"`
+	LastRunAt  time.Time `json:"lastRunAt"`
+	NextRunAt  time.Time `json:"nextRunAt"`
+	LastTaskId string    `json:"lastTaskId"`
+	LastError  string    `json:"lastError"`
+	Runs       int       `json:"runs"`
+}
+
+// Validate check schedule of job, payload is checked by TargetsBuilder
+func (job Job) Validate() error {
+	if job.Name == "" {
+		return fmt.Errorf("job name can not be empty")
+	}
+	if job.Interval < 0 || job.Jitter < 0 {
+		return fmt.Errorf("interval and jitter can not be negative")
+	}
+	if (job.Interval > 0) == (job.Cron != "") {
+		return fmt.Errorf("one of interval and cron must be set")
+	}
+	if job.Cron != "" {
+		if _, err := cronParser.Parse(job.Cron); err != nil {
+			return fmt.Errorf("invalid cron expression %s. %s", job.Cron, err)
+		}
+	}
+	if len(job.Payload) == 0 {
+		return fmt.Errorf("job payload can not be empty")
+	}
+	if _, err := dispatcher.ParsePriority(job.Priority); err != nil {
+		return err
+	}
+	return nil
+}
+
+// schedule return cron schedule of job
+func (job Job) schedule() (cron.Schedule, error) {
+	if job.Cron != "" {
+		return cronParser.Parse(job.Cron)
+	}
+	return cron.Every(time.Duration(job.Interval) * time.Second), nil
+}
+
+// next return run time of scheduled time with jitter
+func (job Job) next(scheduled time.Time) time.Time {
+	var next = scheduled
+	if job.Jitter > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(job.Jitter) * int64(time.Second))))
+	}
+	return next
+}
@@ -0,0 +1,34 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestJob_Validate(t *testing.T) {
+	var payload = json.RawMessage(`{"targets":["127.0.0.1"]}`)
+	tests := []struct {
+		name    string
+		job     Job
+		wantErr bool
+	}{
+		{name: "interval", job: Job{Name: "job", Interval: 60, Payload: payload}},
+		{name: "cron", job: Job{Name: "job", Cron: "*/5 * * * *", Jitter: 10, Payload: payload}},
+		{name: "descriptor", job: Job{Name: "job", Cron: "@every 30s", Payload: payload}},
+		{name: "no name", job: Job{Interval: 60, Payload: payload}, wantErr: true},
+		{name: "no schedule", job: Job{Name: "job", Payload: payload}, wantErr: true},
+		{name: "both schedule", job: Job{Name: "job", Interval: 60, Cron: "@hourly", Payload: payload}, wantErr: true},
+		{name: "invalid cron", job: Job{Name: "job", Cron: "* * *", Payload: payload}, wantErr: true},
+		{name: "negative jitter", job: Job{Name: "job", Interval: 60, Jitter: -1, Payload: payload}, wantErr: true},
+		{name: "no payload", job: Job{Name: "job", Interval: 60}, wantErr: true},
+		{name: "bulk", job: Job{Name: "job", Interval: 60, Priority: "bulk", Payload: payload}},
+		{name: "unknown priority", job: Job{Name: "job", Interval: 60, Priority: "urgent", Payload: payload}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.job.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,365 @@
+package scheduler
+
+import (
+	"context"
+	"detect-server/connector"
+	"detect-server/detector"
+	"detect-server/dispatcher"
+	"detect-server/log"
+	"detect-server/storage"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInvalidJob job or its payload is invalid
+	ErrInvalidJob = errors.New("invalid job")
+	// ErrJobNotFound job of id does not exist
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobExists job of id already exists
+	ErrJobExists = errors.New("job already exists")
+)
+
+// TargetsBuilder convert payload of detect type to targets
+type TargetsBuilder func(detectType detector.DetectType, payload []byte) (detector.TargetIterator, error)
+
+// Scheduler run recurring jobs, every run of job is published as task
+type Scheduler interface {
+	Start() error
+	Stop() error
+	AddPublisher(connector.Publisher[dispatcher.Task])
+	AddTracker(tracker dispatcher.Tracker)
+	AddBuilder(builder TargetsBuilder)
+	// AddStore persist jobs into store, jobs in store are resumed on Start
+	AddStore(store storage.Store)
+	Create(job Job) (Job, error)
+	Update(id string, job Job) (Job, error)
+	Delete(id string) error
+	Get(id string) (Job, bool)
+	List() []Job
+}
+
+type scheduledJob struct {
+	job        Job
+	cancelFunc context.CancelFunc
+}
+
+type cronScheduler struct {
+	lock       sync.RWMutex
+	jobs       map[string]*scheduledJob
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+	publisher  connector.Publisher[dispatcher.Task]
+	tracker    dispatcher.Tracker
+	builder    TargetsBuilder
+	store      storage.Store
+}
+
+func NewScheduler() Scheduler {
+	return &cronScheduler{
+		jobs: make(map[string]*scheduledJob),
+	}
+}
+
+func (scheduler *cronScheduler) AddPublisher(publisher connector.Publisher[dispatcher.Task]) {
+	scheduler.publisher = publisher
+}
+
+func (scheduler *cronScheduler) AddTracker(tracker dispatcher.Tracker) {
+	scheduler.tracker = tracker
+}
+
+func (scheduler *cronScheduler) AddBuilder(builder TargetsBuilder) {
+	scheduler.builder = builder
+}
+
+func (scheduler *cronScheduler) AddStore(store storage.Store) {
+	scheduler.store = store
+}
+
+func (scheduler *cronScheduler) Start() error {
+	if scheduler.publisher == nil {
+		return fmt.Errorf("publisher is invalid")
+	}
+	if scheduler.tracker == nil {
+		return fmt.Errorf("tracker is invalid")
+	}
+	if scheduler.builder == nil {
+		return fmt.Errorf("targets builder is invalid")
+	}
+	if err := scheduler.load(); err != nil {
+		return fmt.Errorf("load jobs failed. %s", err)
+	}
+
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+	scheduler.ctx, scheduler.cancelFunc = context.WithCancel(context.Background())
+	for _, scheduled := range scheduler.jobs {
+		scheduler.run(scheduled)
+	}
+	return nil
+}
+
+func (scheduler *cronScheduler) Stop() error {
+	if scheduler.cancelFunc == nil {
+		return fmt.Errorf("scheduler is not started")
+	}
+	scheduler.cancelFunc()
+	return nil
+}
+
+// load restore jobs from store, invalid jobs are kept in store but not run
+func (scheduler *cronScheduler) load() error {
+	if scheduler.store == nil {
+		return nil
+	}
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+	return scheduler.store.ForEach(storage.JobBucket, func(key string, data []byte) error {
+		var job Job
+		if err := json.Unmarshal(data, &job); err != nil {
+			log.Logger.Warnf("decode stored job %s failed. %s", key, err)
+			return nil
+		}
+		if err := scheduler.check(job); err != nil {
+			log.Logger.Warnf("stored job %s is invalid. %s", key, err)
+			return nil
+		}
+		job.Id = key
+		job.NextRunAt = time.Time{}
+		scheduler.jobs[key] = &scheduledJob{job: job}
+		log.Logger.Infof("load job %s(%s) from store", job.Name, job.Id)
+		return nil
+	})
+}
+
+// save write job into store, caller must hold the lock
+func (scheduler *cronScheduler) save(job Job) error {
+	if scheduler.store == nil {
+		return nil
+	}
+	return scheduler.store.Put(storage.JobBucket, job.Id, job)
+}
+
+// check validate job and its payload
+func (scheduler *cronScheduler) check(job Job) error {
+	if err := job.Validate(); err != nil {
+		return fmt.Errorf("%w. %s", ErrInvalidJob, err)
+	}
+	if scheduler.builder != nil {
+		if _, err := scheduler.builder(job.Type, job.Payload); err != nil {
+			return fmt.Errorf("%w payload. %w", ErrInvalidJob, err)
+		}
+	}
+	return nil
+}
+
+// Create add job, run statistics of job are reset since they are only
+// written by scheduler
+func (scheduler *cronScheduler) Create(job Job) (Job, error) {
+	if err := scheduler.check(job); err != nil {
+		return Job{}, err
+	}
+	if job.Id == "" {
+		job.Id = uuid.NewString()
+	}
+	job.CreatedAt = time.Now()
+	job.LastRunAt = time.Time{}
+	job.NextRunAt = time.Time{}
+	job.LastTaskId = ""
+	job.LastError = ""
+	job.Runs = 0
+
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+	if _, ok := scheduler.jobs[job.Id]; ok {
+		return Job{}, fmt.Errorf("%w, id %s", ErrJobExists, job.Id)
+	}
+	if err := scheduler.save(job); err != nil {
+		return Job{}, fmt.Errorf("store job failed. %s", err)
+	}
+	var scheduled = &scheduledJob{job: job}
+	scheduler.jobs[job.Id] = scheduled
+	scheduler.run(scheduled)
+	return scheduled.job, nil
+}
+
+// Update replace definition of job, run statistics are kept
+func (scheduler *cronScheduler) Update(id string, job Job) (Job, error) {
+	if err := scheduler.check(job); err != nil {
+		return Job{}, err
+	}
+
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+	scheduled, ok := scheduler.jobs[id]
+	if !ok {
+		return Job{}, fmt.Errorf("%w, id %s", ErrJobNotFound, id)
+	}
+	job.Id = id
+	job.CreatedAt = scheduled.job.CreatedAt
+	job.LastRunAt = scheduled.job.LastRunAt
+	job.LastTaskId = scheduled.job.LastTaskId
+	job.LastError = scheduled.job.LastError
+	job.Runs = scheduled.job.Runs
+	job.NextRunAt = time.Time{}
+	if err := scheduler.save(job); err != nil {
+		return Job{}, fmt.Errorf("store job failed. %s", err)
+	}
+	scheduled.stop()
+	scheduled.job = job
+	scheduler.run(scheduled)
+	return scheduled.job, nil
+}
+
+func (scheduler *cronScheduler) Delete(id string) error {
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+	scheduled, ok := scheduler.jobs[id]
+	if !ok {
+		return fmt.Errorf("%w, id %s", ErrJobNotFound, id)
+	}
+	if scheduler.store != nil {
+		if err := scheduler.store.Delete(storage.JobBucket, id); err != nil {
+			return fmt.Errorf("delete stored job failed. %s", err)
+		}
+	}
+	scheduled.stop()
+	delete(scheduler.jobs, id)
+	return nil
+}
+
+func (scheduler *cronScheduler) Get(id string) (Job, bool) {
+	scheduler.lock.RLock()
+	defer scheduler.lock.RUnlock()
+	scheduled, ok := scheduler.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return scheduled.job, true
+}
+
+// List return all jobs order by create time
+func (scheduler *cronScheduler) List() []Job {
+	scheduler.lock.RLock()
+	defer scheduler.lock.RUnlock()
+	var jobs = make([]Job, 0, len(scheduler.jobs))
+	for _, scheduled := range scheduler.jobs {
+		jobs = append(jobs, scheduled.job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+	})
+	return jobs
+}
+
+func (scheduled *scheduledJob) stop() {
+	if scheduled.cancelFunc != nil {
+		scheduled.cancelFunc()
+		scheduled.cancelFunc = nil
+	}
+}
+
+// run start goroutine of enabled job, caller must hold the lock
+func (scheduler *cronScheduler) run(scheduled *scheduledJob) {
+	if scheduler.ctx == nil || !scheduled.job.Enabled {
+		return
+	}
+	schedule, err := scheduled.job.schedule()
+	if err != nil {
+		log.Logger.Errorf("schedule job %s failed. %s", scheduled.job.Id, err)
+		return
+	}
+	var ctx, cancelFunc = context.WithCancel(scheduler.ctx)
+	scheduled.cancelFunc = cancelFunc
+	var job = scheduled.job
+
+	go func() {
+		log.Logger.Infof("start scheduled job %s(%s)", job.Name, job.Id)
+		// jitter is applied to every run, base keeps runs from drifting
+		var base = time.Now()
+		for {
+			base = schedule.Next(base)
+			var next = job.next(base)
+			scheduler.setNextRun(job.Id, next)
+			var timer = time.NewTimer(time.Until(next))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				log.Logger.Infof("stop scheduled job %s(%s)", job.Name, job.Id)
+				return
+			case <-timer.C:
+				scheduler.trigger(job)
+			}
+		}
+	}()
+}
+
+// trigger publish one run of job as task, run is skipped while task of
+// last run is unfinished so slow job never piles up tasks
+func (scheduler *cronScheduler) trigger(job Job) {
+	if lastTaskId, running := scheduler.lastTaskRunning(job.Id); running {
+		log.Logger.Warnf("skip run of job %s, task %s of last run is unfinished", job.Id, lastTaskId)
+		scheduler.lock.Lock()
+		defer scheduler.lock.Unlock()
+		if scheduled, ok := scheduler.jobs[job.Id]; ok {
+			scheduled.job.LastError = fmt.Sprintf("run is skipped, task %s of last run is unfinished", lastTaskId)
+		}
+		return
+	}
+	var taskId, errMessage string
+	targets, err := scheduler.builder(job.Type, job.Payload)
+	if err != nil {
+		errMessage = err.Error()
+		log.Logger.Errorf("build targets of job %s failed. %s", job.Id, err)
+	} else {
+		priority, _ := dispatcher.ParsePriority(job.Priority)
+		var task = dispatcher.NewTask(job.Name, priority, targets)
+		scheduler.tracker.Track(task)
+		scheduler.publisher.Publish() <- task
+		taskId = task.Id()
+		log.Logger.Debugf("job %s published task %s", job.Id, taskId)
+	}
+
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+	if scheduled, ok := scheduler.jobs[job.Id]; ok {
+		scheduled.job.LastRunAt = time.Now()
+		scheduled.job.LastTaskId = taskId
+		scheduled.job.LastError = errMessage
+		scheduled.job.Runs++
+		if err = scheduler.save(scheduled.job); err != nil {
+			log.Logger.Errorf("store job %s failed. %s", job.Id, err)
+		}
+	}
+}
+
+// lastTaskRunning return task of last run of job and whether it is
+// unfinished
+func (scheduler *cronScheduler) lastTaskRunning(id string) (string, bool) {
+	scheduler.lock.RLock()
+	scheduled, ok := scheduler.jobs[id]
+	var lastTaskId string
+	if ok {
+		lastTaskId = scheduled.job.LastTaskId
+	}
+	scheduler.lock.RUnlock()
+	if lastTaskId == "" {
+		return "", false
+	}
+	status, ok := scheduler.tracker.Get(lastTaskId)
+	return lastTaskId, ok && !status.Finished()
+}
+
+func (scheduler *cronScheduler) setNextRun(id string, next time.Time) {
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+	if scheduled, ok := scheduler.jobs[id]; ok {
+		scheduled.job.NextRunAt = next
+	}
+}
@@ -0,0 +1,257 @@
+package scheduler
+
+import (
+	"detect-server/connector"
+	"detect-server/detector"
+	"detect-server/dispatcher"
+	"detect-server/log"
+	"detect-server/storage"
+	"encoding/json"
+	"errors"
+	"go.uber.org/zap"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	log.Logger = zap.NewNop().Sugar()
+}
+
+// buildTargets build one icmp target of every address in payload
+func buildTargets(_ detector.DetectType, payload []byte) (detector.TargetIterator, error) {
+	var body struct {
+		Targets []string `json:"targets"`
+	}
+	if err := json.Unmarshal(payload, &body); err != nil {
+		return nil, err
+	}
+	if len(body.Targets) == 0 {
+		return nil, errors.New("targets can not be empty")
+	}
+	var targets = make([]detector.Target, 0, len(body.Targets))
+	for _, address := range body.Targets {
+		targets = append(targets, detector.NewDetectTarget(detector.ICMPDetect, address, detector.DetectOptions[detector.IcmpOptions]{}))
+	}
+	return detector.NewSliceIterator(targets...), nil
+}
+
+func newTestScheduler(t *testing.T) (Scheduler, dispatcher.Tracker, connector.Connector[dispatcher.Task]) {
+	var (
+		scheduler = NewScheduler()
+		tracker   = dispatcher.NewTracker(dispatcher.TrackerOptions{Retention: time.Minute})
+		tasks     = connector.NewChanConnector[dispatcher.Task](connector.Options{MaxBufferSize: 10})
+	)
+	scheduler.AddPublisher(tasks)
+	scheduler.AddTracker(tracker)
+	scheduler.AddBuilder(buildTargets)
+	if err := scheduler.Start(); err != nil {
+		t.Fatalf("start scheduler failed. %s", err)
+	}
+	return scheduler, tracker, tasks
+}
+
+// waitJob return job once check passes, statistics of run are written
+// right after its task is published
+func waitJob(scheduler Scheduler, id string, check func(job Job) bool) (Job, bool) {
+	var deadline = time.Now().Add(time.Second)
+	for {
+		job, _ := scheduler.Get(id)
+		if check(job) {
+			return job, true
+		}
+		if time.Now().After(deadline) {
+			return job, false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCronScheduler_Trigger(t *testing.T) {
+	var payload = json.RawMessage(`{"targets":["127.0.0.1"]}`)
+	tests := []struct {
+		name string
+		job  Job
+	}{
+		{name: "interval", job: Job{Name: "interval", Interval: 1, Payload: payload, Enabled: true}},
+		{name: "cron", job: Job{Name: "cron", Cron: "@every 1s", Payload: payload, Enabled: true}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			scheduler, tracker, tasks := newTestScheduler(t)
+			defer scheduler.Stop()
+			job, err := scheduler.Create(tt.job)
+			if err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+			var receive = func(within time.Duration) (dispatcher.Task, bool) {
+				select {
+				case task := <-tasks.Receive():
+					return task, true
+				case <-time.After(within):
+					return nil, false
+				}
+			}
+
+			task, ok := receive(2500 * time.Millisecond)
+			if !ok {
+				t.Fatalf("job is not triggered")
+			}
+			if task.Name() != tt.job.Name || task.Targets().Count() != 1 {
+				t.Errorf("published task = %s with %d targets, want %s with 1 target", task.Name(), task.Targets().Count(), tt.job.Name)
+			}
+			if job, ok = waitJob(scheduler, job.Id, func(job Job) bool {
+				return job.Runs == 1 && job.LastTaskId == task.Id() && !job.LastRunAt.IsZero()
+			}); !ok {
+				t.Errorf("job after run = %+v, want 1 run of task %s", job, task.Id())
+			}
+
+			// task of last run is unfinished, so next run is skipped
+			if task, ok := receive(1500 * time.Millisecond); ok {
+				t.Fatalf("task %s is published while last task is unfinished", task.Id())
+			}
+			if job, _ = scheduler.Get(job.Id); job.Runs != 1 || !strings.Contains(job.LastError, "skipped") {
+				t.Errorf("job after skipped run = %+v, want 1 run and skipped error", job)
+			}
+
+			tracker.Start(task.Id())
+			tracker.Done(dispatcher.DefaultMessage{TaskId: task.Id(), Target: "127.0.0.1"})
+			if _, ok = receive(1500 * time.Millisecond); !ok {
+				t.Fatalf("job is not triggered after last task finished")
+			}
+			if job, ok = waitJob(scheduler, job.Id, func(job Job) bool {
+				return job.Runs == 2 && job.LastError == ""
+			}); !ok {
+				t.Errorf("job after second run = %+v, want 2 runs without error", job)
+			}
+		})
+	}
+}
+
+func TestCronScheduler_Crud(t *testing.T) {
+	scheduler, _, _ := newTestScheduler(t)
+	defer scheduler.Stop()
+	var payload = json.RawMessage(`{"targets":["127.0.0.1"]}`)
+
+	// run statistics of input are ignored
+	var createdAt = time.Now().Add(-time.Hour)
+	job, err := scheduler.Create(Job{Name: "first", Interval: 60, Payload: payload,
+		CreatedAt: createdAt, LastTaskId: "forged", LastError: "forged", Runs: 10})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if job.Id == "" || !job.CreatedAt.After(createdAt) || job.LastTaskId != "" || job.LastError != "" || job.Runs != 0 {
+		t.Errorf("Create() = %+v, want new job without run statistics", job)
+	}
+	if _, err = scheduler.Create(Job{Name: "invalid", Payload: payload}); !errors.Is(err, ErrInvalidJob) {
+		t.Errorf("Create() invalid job error = %v, want %v", err, ErrInvalidJob)
+	}
+	if _, err = scheduler.Create(Job{Name: "invalid payload", Interval: 60, Payload: json.RawMessage(`{}`)}); !errors.Is(err, ErrInvalidJob) {
+		t.Errorf("Create() invalid payload error = %v, want %v", err, ErrInvalidJob)
+	}
+	second, _ := scheduler.Create(Job{Name: "second", Cron: "@hourly", Payload: payload})
+
+	updated, err := scheduler.Update(job.Id, Job{Name: "renamed", Interval: 30, Payload: payload, Runs: 10})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if updated.Id != job.Id || updated.Name != "renamed" || updated.Interval != 30 ||
+		!updated.CreatedAt.Equal(job.CreatedAt) || updated.Runs != 0 {
+		t.Errorf("Update() = %+v, want renamed job keeping run statistics", updated)
+	}
+	if _, err = scheduler.Update("unknown", Job{Name: "job", Interval: 30, Payload: payload}); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Update() unknown job error = %v, want %v", err, ErrJobNotFound)
+	}
+
+	if jobs := scheduler.List(); len(jobs) != 2 || jobs[0].Id != job.Id || jobs[1].Id != second.Id {
+		t.Errorf("List() = %+v, want jobs in order of creation", jobs)
+	}
+	if err = scheduler.Delete(job.Id); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, ok := scheduler.Get(job.Id); ok {
+		t.Errorf("Get() deleted job is found")
+	}
+	if err = scheduler.Delete(job.Id); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Delete() deleted job error = %v, want %v", err, ErrJobNotFound)
+	}
+}
+
+func TestCronScheduler_Reload(t *testing.T) {
+	var options = storage.Options{Path: filepath.Join(t.TempDir(), "detect.db")}
+	var start = func() (Scheduler, storage.Store, connector.Connector[dispatcher.Task]) {
+		store, err := storage.NewStore(options)
+		if err != nil {
+			t.Fatalf("NewStore() error = %v", err)
+		}
+		var (
+			scheduler = NewScheduler()
+			tasks     = connector.NewChanConnector[dispatcher.Task](connector.Options{MaxBufferSize: 10})
+		)
+		scheduler.AddPublisher(tasks)
+		scheduler.AddTracker(dispatcher.NewTracker(dispatcher.TrackerOptions{Retention: time.Minute}))
+		scheduler.AddBuilder(buildTargets)
+		scheduler.AddStore(store)
+		if err = scheduler.Start(); err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+		return scheduler, store, tasks
+	}
+
+	var payload = json.RawMessage(`{"targets":["127.0.0.1"]}`)
+	scheduler, store, tasks := start()
+	job, err := scheduler.Create(Job{Name: "reload", Interval: 1, Payload: payload, Enabled: true})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	disabled, err := scheduler.Create(Job{Name: "disabled", Interval: 1, Payload: payload})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	select {
+	case <-tasks.Receive():
+	case <-time.After(2500 * time.Millisecond):
+		t.Fatalf("job is not triggered")
+	}
+	var ok bool
+	if job, ok = waitJob(scheduler, job.Id, func(job Job) bool { return job.Runs == 1 }); !ok {
+		t.Fatalf("job before restart = %+v, want 1 run", job)
+	}
+	_ = scheduler.Stop()
+	if err = store.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// scheduler of restarted process
+	scheduler, store, tasks = start()
+	defer store.Close()
+	defer scheduler.Stop()
+	if jobs := scheduler.List(); len(jobs) != 2 || jobs[0].Id != job.Id || jobs[1].Id != disabled.Id {
+		t.Fatalf("List() after restart = %+v, want stored jobs", jobs)
+	}
+	reloaded, _ := scheduler.Get(job.Id)
+	if reloaded.Name != job.Name || reloaded.Runs != 1 || reloaded.LastTaskId != job.LastTaskId {
+		t.Errorf("Get() after restart = %+v, want stored job", reloaded)
+	}
+	if reloaded, _ = scheduler.Get(disabled.Id); !reloaded.NextRunAt.IsZero() {
+		t.Errorf("Get() disabled job after restart = %+v, want no next run", reloaded)
+	}
+
+	// only enabled job is re-armed
+	select {
+	case task := <-tasks.Receive():
+		if task.Name() != job.Name {
+			t.Errorf("task %s is published after restart, want %s", task.Name(), job.Name)
+		}
+	case <-time.After(2500 * time.Millisecond):
+		t.Fatalf("job is not triggered after restart")
+	}
+	if reloaded, ok = waitJob(scheduler, job.Id, func(job Job) bool {
+		return job.Runs == 2 && !job.NextRunAt.IsZero()
+	}); !ok {
+		t.Errorf("job after restart run = %+v, want 2 runs and next run", reloaded)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"github.com/IBM/sarama"
 	"github.com/spf13/viper"
+	"sync"
 )
 
 type KafkaSenderOptions struct {
@@ -47,6 +48,8 @@ type KafkaSender struct {
 	ctx        context.Context
 	cancelFunc context.CancelFunc
 	receiver   connector.Receiver[any]
+	// clients wait all kafka clients flushed and closed
+	clients sync.WaitGroup
 }
 
 func NewKafkaSender(options KafkaSenderOptions) Sender[any] {
@@ -89,34 +92,71 @@ func (kafka *KafkaSender) startKafkaClient(name string) error {
 		return fmt.Errorf("create kafka productor failed. %s\n", err)
 	}
 
+	// errors must be consumed or producer is blocked, channel is closed
+	// when producer is closed
+	go func(client sarama.AsyncProducer) {
+		for err := range client.Errors() {
+			log.Logger.Warnf("%s send message to kafka failed. %s", name, err)
+		}
+	}(kafkaClient)
+
+	kafka.clients.Add(1)
 	go func(ctx context.Context, client sarama.AsyncProducer) {
+		defer kafka.clients.Done()
 		log.Logger.Infof("start kafka sender %s", name)
 		for {
 			select {
 			case <-ctx.Done():
-				log.Logger.Infof("close kafka client")
-				_ = client.Close()
-			case msg := <-kafka.receiver.Receive():
-				data, err := json.Marshal(msg)
-				if err != nil {
-					log.Logger.Debugf("send message to kafka failed. %s", err)
-					continue
-				}
-				client.Input() <- &sarama.ProducerMessage{
-					Topic: kafka.options.Topic,
-					Key:   sarama.StringEncoder(kafka.options.MessageKey),
-					Value: sarama.StringEncoder(data),
+				// messages left in receiver are sent before client is closed,
+				// close flushes buffered messages of client
+				var count = kafka.drain(client)
+				log.Logger.Infof("close kafka sender %s, %d messages left are sent", name, count)
+				if err := client.Close(); err != nil {
+					log.Logger.Warnf("close kafka sender %s failed. %s", name, err)
 				}
+				return
+			case msg := <-kafka.receiver.Receive():
+				kafka.send(client, msg)
 			}
 		}
 	}(kafka.ctx, kafkaClient)
 	return nil
 }
 
+// drain send messages in receiver until it is empty, return count of them
+func (kafka *KafkaSender) drain(client sarama.AsyncProducer) int {
+	var count int
+	for {
+		select {
+		case msg := <-kafka.receiver.Receive():
+			kafka.send(client, msg)
+			count++
+		default:
+			return count
+		}
+	}
+}
+
+func (kafka *KafkaSender) send(client sarama.AsyncProducer, msg any) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Logger.Debugf("send message to kafka failed. %s", err)
+		return
+	}
+	client.Input() <- &sarama.ProducerMessage{
+		Topic: kafka.options.Topic,
+		Key:   sarama.StringEncoder(kafka.options.MessageKey),
+		Value: sarama.StringEncoder(data),
+	}
+}
+
+// Stop send messages left in receiver and wait all kafka clients flushed
 func (kafka *KafkaSender) Stop() error {
 	if kafka.cancelFunc == nil {
 		return fmt.Errorf("kafka sender already closed")
 	}
 	kafka.cancelFunc()
+	kafka.cancelFunc = nil
+	kafka.clients.Wait()
 	return nil
 }
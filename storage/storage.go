@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/viper"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	JobBucket  = "jobs"
+	TaskBucket = "tasks"
+)
+
+// Store persist json encoded values by bucket and key
+type Store interface {
+	Put(bucket string, key string, value any) error
+	Delete(bucket string, key string) error
+	// ForEach call fn with every value in bucket
+	ForEach(bucket string, fn func(key string, data []byte) error) error
+	Close() error
+}
+
+type Options struct {
+	// Path of bolt database file, storage is disabled if it is empty
+	Path string
+	// OpenTimeout wait for file lock of database
+	OpenTimeout time.Duration
+}
+
+func NewOptions() Options {
+	var options = Options{
+		Path:        viper.GetString("storage.path"),
+		OpenTimeout: time.Duration(viper.GetInt("storage.timeout")) * time.Millisecond,
+	}
+
+	if options.OpenTimeout <= 0 {
+		options.OpenTimeout = 3 * time.Second
+	}
+	return options
+}
+
+// NewStore open bolt store at options.Path, store doing nothing is
+// returned if path is empty
+func NewStore(options Options) (Store, error) {
+	if options.Path == "" {
+		return &nopStore{}, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(options.Path), 0o755); err != nil {
+		return nil, fmt.Errorf("create storage directory failed. %s", err)
+	}
+	db, err := bolt.Open(options.Path, 0o600, &bolt.Options{Timeout: options.OpenTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("open storage %s failed. %s", options.Path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range []string{JobBucket, TaskBucket} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(bucket)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("create storage buckets failed. %s", err)
+	}
+	return &boltStore{db: db}, nil
+}
+
+type boltStore struct {
+	db *bolt.DB
+}
+
+func (store *boltStore) Put(bucket string, key string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return store.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), data)
+	})
+}
+
+func (store *boltStore) Delete(bucket string, key string) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(key))
+	})
+}
+
+func (store *boltStore) ForEach(bucket string, fn func(key string, data []byte) error) error {
+	return store.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			return fn(string(k), v)
+		})
+	})
+}
+
+func (store *boltStore) Close() error {
+	return store.db.Close()
+}
+
+type nopStore struct {
+}
+
+func (store *nopStore) Put(string, string, any) error {
+	return nil
+}
+
+func (store *nopStore) Delete(string, string) error {
+	return nil
+}
+
+func (store *nopStore) ForEach(string, func(key string, data []byte) error) error {
+	return nil
+}
+
+func (store *nopStore) Close() error {
+	return nil
+}
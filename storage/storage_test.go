@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+)
+
+func TestBoltStore(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "data", "test.db")
+	store, err := NewStore(Options{Path: path})
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	type value struct {
+		Name string `json:"name"`
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if err = store.Put(JobBucket, key, value{Name: key}); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+	if err = store.Delete(JobBucket, "b"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err = store.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// values must survive reopening
+	if store, err = NewStore(Options{Path: path}); err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	defer store.Close()
+	var got = make(map[string]string)
+	err = store.ForEach(JobBucket, func(key string, data []byte) error {
+		var v value
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		got[key] = v.Name
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ForEach() error = %v", err)
+	}
+	if len(got) != 2 || got["a"] != "a" || got["c"] != "c" {
+		t.Errorf("ForEach() got = %v", got)
+	}
+}
+
+func TestNopStore(t *testing.T) {
+	store, err := NewStore(Options{})
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	if err = store.Put(TaskBucket, "a", 1); err != nil {
+		t.Errorf("Put() error = %v", err)
+	}
+	var count int
+	_ = store.ForEach(TaskBucket, func(string, []byte) error {
+		count++
+		return nil
+	})
+	if count != 0 {
+		t.Errorf("ForEach() count = %d, want 0", count)
+	}
+}
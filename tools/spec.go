@@ -0,0 +1,267 @@
+package tools
+
+import (
+	"fmt"
+	"github.com/seancfoley/ipaddress-go/ipaddr"
+	"math"
+	"math/big"
+	"strings"
+	"unicode"
+)
+
+// SpecError malformed token of target specification, Index is the index
+// of specification in the list and Offset is byte offset of token in it
+type SpecError struct {
+	Index  int    `json:"index"`
+	Offset int    `json:"offset"`
+	Token  string `json:"token"`
+	Reason string `json:"reason"`
+}
+
+func (e *SpecError) Error() string {
+	return fmt.Sprintf("invalid target %q at target %d offset %d: %s", e.Token, e.Index, e.Offset, e.Reason)
+}
+
+// TargetSpec targets parsed from specifications. every specification is
+// a list of tokens separated by comma or space, token is one of
+//
+//	10.0.0.1             single ip
+//	10.0.0.0/24          cidr network
+//	10.0.0.1-10.0.0.50   range of ips
+//	example.com          hostname, resolved by detector when it is dispatched
+//	!10.0.0.0/28         exclude ip, network or range from all specifications
+type TargetSpec struct {
+	ranges    []*ipaddr.IPAddressSeqRange
+	hostnames []string
+	count     int
+}
+
+type specToken struct {
+	index  int
+	offset int
+	text   string
+}
+
+// ParseTargetSpec parse specifications into targets, network or range with
+// more than 2^maxHostBits ips is rejected, maxHostBits <= 0 means no limit
+func ParseTargetSpec(specs []string, maxHostBits int) (*TargetSpec, error) {
+	var (
+		includes  []*ipaddr.IPAddressSeqRange
+		excludes  []*ipaddr.IPAddressSeqRange
+		hostnames []string
+		seen      = make(map[string]struct{})
+	)
+	for _, token := range tokenizeSpecs(specs) {
+		var text, exclude = strings.CutPrefix(token.text, "!")
+		if text == "" {
+			return nil, token.error("empty exclusion")
+		}
+		rng, isHost, reason := parseSpecToken(text)
+		if reason != "" {
+			return nil, token.error(reason)
+		}
+		if isHost {
+			if exclude {
+				return nil, token.error("hostname can not be excluded")
+			}
+			var host = strings.ToLower(strings.TrimSuffix(text, "."))
+			if _, ok := seen[host]; !ok {
+				seen[host] = struct{}{}
+				hostnames = append(hostnames, host)
+			}
+			continue
+		}
+		if exclude {
+			excludes = append(excludes, rng)
+			continue
+		}
+		if maxHostBits > 0 && rng.GetCount().Cmp(new(big.Int).Lsh(big.NewInt(1), uint(maxHostBits))) > 0 {
+			return nil, token.error(fmt.Sprintf("contains more than 2^%d ips", maxHostBits))
+		}
+		includes = append(includes, rng)
+	}
+
+	var spec = &TargetSpec{hostnames: hostnames}
+	for _, ranges := range splitByVersion(includes) {
+		ranges = ranges[0].Join(ranges[1:]...)
+		for _, exclude := range excludes {
+			var remain = make([]*ipaddr.IPAddressSeqRange, 0, len(ranges))
+			for _, rng := range ranges {
+				remain = append(remain, rng.Subtract(exclude)...)
+			}
+			ranges = remain
+		}
+		spec.ranges = append(spec.ranges, ranges...)
+	}
+
+	var count = big.NewInt(int64(len(hostnames)))
+	for _, rng := range spec.ranges {
+		count.Add(count, rng.GetCount())
+	}
+	spec.count = math.MaxInt
+	if count.IsInt64() && count.Int64() < math.MaxInt {
+		spec.count = int(count.Int64())
+	}
+	return spec, nil
+}
+
+// Count number of all targets, it is math.MaxInt if targets are too many
+// to be counted by int
+func (spec *TargetSpec) Count() int {
+	return spec.count
+}
+
+// Iterator create iterator of all targets, ips in ascending order are
+// followed by hostnames
+func (spec *TargetSpec) Iterator() *SpecIterator {
+	return &SpecIterator{spec: spec}
+}
+
+// SpecIterator iterate targets of TargetSpec one by one
+type SpecIterator struct {
+	spec     *TargetSpec
+	ranges   int
+	hosts    int
+	iterator ipaddr.Iterator[*ipaddr.IPAddress]
+}
+
+// Next return next target, ok is false after the last target
+func (iterator *SpecIterator) Next() (string, bool) {
+	for {
+		if iterator.iterator != nil && iterator.iterator.HasNext() {
+			return iterator.iterator.Next().GetNetNetIPAddr().String(), true
+		}
+		if iterator.ranges >= len(iterator.spec.ranges) {
+			break
+		}
+		iterator.iterator = iterator.spec.ranges[iterator.ranges].Iterator()
+		iterator.ranges++
+	}
+	if iterator.hosts < len(iterator.spec.hostnames) {
+		iterator.hosts++
+		return iterator.spec.hostnames[iterator.hosts-1], true
+	}
+	return "", false
+}
+
+// Count number of all targets of iterator
+func (iterator *SpecIterator) Count() int {
+	return iterator.spec.count
+}
+
+func (token specToken) error(reason string) *SpecError {
+	return &SpecError{Index: token.index, Offset: token.offset, Token: token.text, Reason: reason}
+}
+
+// tokenizeSpecs split specifications by comma and space
+func tokenizeSpecs(specs []string) []specToken {
+	var tokens []specToken
+	for index, spec := range specs {
+		var start = -1
+		for offset, r := range spec + "," {
+			if r == ',' || unicode.IsSpace(r) {
+				if start >= 0 {
+					tokens = append(tokens, specToken{index: index, offset: start, text: spec[start:offset]})
+					start = -1
+				}
+				continue
+			}
+			if start < 0 {
+				start = offset
+			}
+		}
+	}
+	return tokens
+}
+
+// parseSpecToken parse token to range of ips or hostname, reason is not
+// empty if token is invalid
+func parseSpecToken(text string) (rng *ipaddr.IPAddressSeqRange, isHost bool, reason string) {
+	if lower, upper, ok := strings.Cut(text, "-"); ok {
+		lowerAddr, lowerErr := parseSingleIp(lower)
+		upperAddr, upperErr := parseSingleIp(upper)
+		if lowerErr == "" && upperErr == "" {
+			if lowerAddr.GetIPVersion() != upperAddr.GetIPVersion() {
+				return nil, false, "range between ipv4 and ipv6"
+			}
+			if lowerAddr.Compare(upperAddr) > 0 {
+				return nil, false, "start of range is greater than end"
+			}
+			return lowerAddr.SpanWithRange(upperAddr), false, ""
+		}
+		// token starts with ip is taken as range, not hostname
+		if lowerErr == "" {
+			return nil, false, "invalid range end. " + upperErr
+		}
+		if isHostname(text) {
+			return nil, true, ""
+		}
+		return nil, false, "invalid range start. " + lowerErr
+	}
+
+	addr, err := ipaddr.NewIPAddressString(text).ToAddress()
+	if err != nil {
+		if isHostname(text) {
+			return nil, true, ""
+		}
+		return nil, false, "invalid ip, network or hostname"
+	}
+	if addr.IsPrefixed() {
+		addr = addr.ToPrefixBlock()
+	}
+	if !addr.IsSequential() {
+		return nil, false, "ips are not sequential"
+	}
+	return addr.ToSequentialRange(), false, ""
+}
+
+// parseSingleIp parse ip without prefix, wildcard or range
+func parseSingleIp(text string) (*ipaddr.IPAddress, string) {
+	addr, err := ipaddr.NewIPAddressString(text).ToAddress()
+	if err != nil {
+		return nil, "invalid ip " + text
+	}
+	if addr.IsPrefixed() || addr.IsMultiple() {
+		return nil, "range bound must be single ip"
+	}
+	return addr, ""
+}
+
+// isHostname check hostname by rfc 1123, last label can not be numeric
+func isHostname(text string) bool {
+	text = strings.TrimSuffix(text, ".")
+	if text == "" || len(text) > 253 {
+		return false
+	}
+	var labels = strings.Split(text, ".")
+	for _, label := range labels {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	return strings.IndexFunc(labels[len(labels)-1], func(r rune) bool { return r < '0' || r > '9' }) >= 0
+}
+
+// splitByVersion group ranges by ip version, ipv4 first
+func splitByVersion(ranges []*ipaddr.IPAddressSeqRange) [][]*ipaddr.IPAddressSeqRange {
+	var v4, v6 []*ipaddr.IPAddressSeqRange
+	for _, rng := range ranges {
+		if rng.IsIPv4() {
+			v4 = append(v4, rng)
+		} else {
+			v6 = append(v6, rng)
+		}
+	}
+	var groups [][]*ipaddr.IPAddressSeqRange
+	for _, group := range [][]*ipaddr.IPAddressSeqRange{v4, v6} {
+		if len(group) > 0 {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
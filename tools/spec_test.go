@@ -0,0 +1,77 @@
+package tools
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseTargetSpec(t *testing.T) {
+	tests := []struct {
+		name        string
+		specs       []string
+		maxHostBits int
+		want        []string
+	}{
+		{name: "single", specs: []string{"10.0.0.1"}, want: []string{"10.0.0.1"}},
+		{name: "range", specs: []string{"10.0.0.1-10.0.0.3"}, want: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+		{name: "cidr", specs: []string{"10.0.0.5/30"}, want: []string{"10.0.0.4", "10.0.0.5", "10.0.0.6", "10.0.0.7"}},
+		{name: "exclusion", specs: []string{"10.0.0.0/29 !10.0.0.0/30"}, want: []string{"10.0.0.4", "10.0.0.5", "10.0.0.6", "10.0.0.7"}},
+		{name: "exclusion across specs", specs: []string{"10.0.0.0/30", "!10.0.0.1-10.0.0.2"}, want: []string{"10.0.0.0", "10.0.0.3"}},
+		{name: "comma list merged", specs: []string{"10.0.0.3,10.0.0.1, 10.0.0.2,10.0.0.1"}, want: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+		{name: "ipv6", specs: []string{"2001:db8::/127 ::1"}, want: []string{"::1", "2001:db8::", "2001:db8::1"}},
+		{name: "mixed versions", specs: []string{"2001:db8::1,10.0.0.1"}, want: []string{"10.0.0.1", "2001:db8::1"}},
+		{name: "hostnames", specs: []string{"my-host.example.com,10.0.0.1,Example.com."}, want: []string{"10.0.0.1", "my-host.example.com", "example.com"}},
+		{name: "all excluded", specs: []string{"10.0.0.1 !10.0.0.0/24"}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := ParseTargetSpec(tt.specs, tt.maxHostBits)
+			if err != nil {
+				t.Fatalf("ParseTargetSpec() error = %v", err)
+			}
+			var got []string
+			var iterator = spec.Iterator()
+			for target, ok := iterator.Next(); ok; target, ok = iterator.Next() {
+				got = append(got, target)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("targets = %v, want %v", got, tt.want)
+			}
+			if spec.Count() != len(tt.want) {
+				t.Errorf("Count() = %d, want %d", spec.Count(), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestParseTargetSpec_Error(t *testing.T) {
+	tests := []struct {
+		name        string
+		specs       []string
+		maxHostBits int
+		want        SpecError
+	}{
+		{name: "bad ip", specs: []string{"10.0.0.1, 10.0.0.300"}, want: SpecError{Index: 0, Offset: 10, Token: "10.0.0.300"}},
+		{name: "bad range end", specs: []string{"10.0.0.1", "10.0.0.1-10.0.0.x"}, want: SpecError{Index: 1, Offset: 0, Token: "10.0.0.1-10.0.0.x"}},
+		{name: "reversed range", specs: []string{"10.0.0.9-10.0.0.1"}, want: SpecError{Token: "10.0.0.9-10.0.0.1"}},
+		{name: "mixed range", specs: []string{"10.0.0.1-::1"}, want: SpecError{Token: "10.0.0.1-::1"}},
+		{name: "empty exclusion", specs: []string{"10.0.0.0/24 !"}, want: SpecError{Offset: 12, Token: "!"}},
+		{name: "hostname exclusion", specs: []string{"10.0.0.0/24 !example.com"}, want: SpecError{Offset: 12, Token: "!example.com"}},
+		{name: "too large", specs: []string{"10.0.0.0/8"}, maxHostBits: 16, want: SpecError{Token: "10.0.0.0/8"}},
+		{name: "bad hostname", specs: []string{"-bad.example.com"}, want: SpecError{Token: "-bad.example.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseTargetSpec(tt.specs, tt.maxHostBits)
+			var specErr *SpecError
+			if !errors.As(err, &specErr) {
+				t.Fatalf("ParseTargetSpec() error = %v, want SpecError", err)
+			}
+			if specErr.Index != tt.want.Index || specErr.Offset != tt.want.Offset ||
+				specErr.Token != tt.want.Token || specErr.Reason == "" {
+				t.Errorf("ParseTargetSpec() error = %+v, want %+v", *specErr, tt.want)
+			}
+		})
+	}
+}